@@ -1,26 +1,391 @@
 package conf
 
-import "github.com/spf13/viper"
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/abdybaevae/customers-app/pkg/custval"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Configuration is read from optional file(yaml, toml or any other format supported by viper) and environment variables.
+// File is ./resources/app.<ext> or CONFIG_FILE, environment variable of key is upper cased key with "_" instead of ".",
+// e.g. SERVER_READ_TIMEOUT overrides server.read_timeout. Every key has default value.
 type Config struct {
-	ServerAddress string `mapstructure:"SERVER_ADDRESS"`
-	DbUser        string `mapstructure:"POSTGRES_USER"`
-	DbPassword    string `mapstructure:"POSTGRES_PASSWORD"`
-	DbName        string `mapstructure:"POSTGRES_DB"`
-	DbHost        string `mapstructure:"POSTGRES_HOST"`
+	Server      ServerConfig      `mapstructure:"server"`
+	Database    DatabaseConfig    `mapstructure:"database"`
+	Pagination  PaginationConfig  `mapstructure:"pagination"`
+	Customers   CustomersConfig   `mapstructure:"customers"`
+	Auth        AuthConfig        `mapstructure:"auth"`
+	RateLimit   RateLimitConfig   `mapstructure:"rate_limit"`
+	Idempotency IdempotencyConfig `mapstructure:"idempotency"`
+	Cache       CacheConfig       `mapstructure:"cache"`
+	Log         LogConfig         `mapstructure:"log"`
+}
+
+type ServerConfig struct {
+	Address           string        `mapstructure:"address"`
+	ReadTimeout       time.Duration `mapstructure:"read_timeout"`
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"`
+	// export streams whole customers list, so timeout must be long enough for it(zero disables timeout)
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	IdleTimeout  time.Duration `mapstructure:"idle_timeout"`
+	// how long in-flight requests are waited for on shutdown
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	// server uses https if both files are set
+	TlsCertFile string `mapstructure:"tls_cert_file"`
+	TlsKeyFile  string `mapstructure:"tls_key_file"`
+	// directory with html templates and static files used instead of embedded ones(for templates development)
+	UiDir string `mapstructure:"ui_dir"`
+	// max size of request body in bytes, import routes use their own limit of uploaded file
+	MaxBodySize   int64 `mapstructure:"max_body_size"`
+	MaxImportSize int64 `mapstructure:"max_import_size"`
+}
+
+type DatabaseConfig struct {
+	// full connection string(postgres:// url), other connection keys are ignored if it's set
+	Dsn             string        `mapstructure:"dsn"`
+	Host            string        `mapstructure:"host"`
+	User            string        `mapstructure:"user"`
+	Password        string        `mapstructure:"password"`
+	Name            string        `mapstructure:"name"`
+	SslMode         string        `mapstructure:"sslmode"`
+	MaxOpenConns    int           `mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+}
+
+type PaginationConfig struct {
+	// customers per list page
+	PageSize int `mapstructure:"page_size"`
+}
+
+type CustomersConfig struct {
+	// allowed customer age range
+	MinAge int `mapstructure:"min_age"`
+	MaxAge int `mapstructure:"max_age"`
+	// allowed gender values, comma separated in environment variable
+	Genders []string `mapstructure:"genders"`
+	// max count of characters, they can't be greater than database columns
+	MaxNameLength    int `mapstructure:"max_name_length"`
+	MaxEmailLength   int `mapstructure:"max_email_length"`
+	MaxAddressLength int `mapstructure:"max_address_length"`
+	// how long deleted customers can be restored before they are removed permanently
+	DeletedRetention time.Duration `mapstructure:"deleted_retention"`
+	// how often deleted customers are checked for removal, zero disables removal
+	PurgeInterval time.Duration `mapstructure:"purge_interval"`
+}
+
+type AuthConfig struct {
+	// user has to log in again after session lifetime
+	SessionLifetime time.Duration `mapstructure:"session_lifetime"`
+	// session cookie is sent only over https, it must be set if tls is terminated by proxy(it's always set for tls of server)
+	SecureCookie bool `mapstructure:"secure_cookie"`
+}
+
+// Token bucket of every client(api key, user or address of anonymous client): bucket of burst size is refilled
+// with rate requests per second. Unsafe requests(form submits, api changes, login) have separate smaller bucket.
+type RateLimitConfig struct {
+	Enabled    bool    `mapstructure:"enabled"`
+	Rate       float64 `mapstructure:"rate"`
+	Burst      int     `mapstructure:"burst"`
+	WriteRate  float64 `mapstructure:"write_rate"`
+	WriteBurst int     `mapstructure:"write_burst"`
+	// bucket of address checked before authentication, it's shared by all clients behind the address(e.g. office nat)
+	AddressRate  float64 `mapstructure:"address_rate"`
+	AddressBurst int     `mapstructure:"address_burst"`
+}
+
+type IdempotencyConfig struct {
+	// how long response of request with Idempotency-Key header is stored, retry after it is handled as new request
+	Retention time.Duration `mapstructure:"retention"`
+	// request which isn't completed after it is considered lost(e.g. server has crashed) and retry handles it again
+	LockTimeout time.Duration `mapstructure:"lock_timeout"`
+}
+
+// Read-through cache of customers, lists and counts in server memory
+type CacheConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// max count of cached entries
+	Size int `mapstructure:"size"`
+	// how long entry is kept, it limits staleness of customers changed by other servers
+	Ttl time.Duration `mapstructure:"ttl"`
 }
 
-func Load() *Config {
+type LogConfig struct {
+	// logrus level name
+	Level string `mapstructure:"level"`
+	// text or json
+	Format string `mapstructure:"format"`
+}
+
+var defaults = map[string]interface{}{
+	"server.address":               ":10001",
+	"server.read_timeout":          "30s",
+	"server.read_header_timeout":   "5s",
+	"server.write_timeout":         "5m",
+	"server.idle_timeout":          "2m",
+	"server.shutdown_timeout":      "30s",
+	"server.tls_cert_file":         "",
+	"server.tls_key_file":          "",
+	"server.ui_dir":                "",
+	"server.max_body_size":         1 << 20,
+	"server.max_import_size":       10 << 20,
+	"database.dsn":                 "",
+	"database.host":                "localhost:5432",
+	"database.user":                "postgres",
+	"database.password":            "",
+	"database.name":                "postgres",
+	"database.sslmode":             "disable",
+	"database.max_open_conns":      20,
+	"database.max_idle_conns":      5,
+	"database.conn_max_lifetime":   "30m",
+	"pagination.page_size":         20,
+	"customers.min_age":            18,
+	"customers.max_age":            60,
+	"customers.genders":            []string{"female", "male", "unspecified"},
+	"customers.max_name_length":    100,
+	"customers.max_email_length":   custval.ColumnEmailLength,
+	"customers.max_address_length": custval.ColumnAddressLength,
+	"customers.deleted_retention":  "720h",
+	"customers.purge_interval":     "1h",
+	"auth.session_lifetime":        "12h",
+	"auth.secure_cookie":           false,
+	"rate_limit.enabled":           true,
+	"rate_limit.rate":              20,
+	"rate_limit.burst":             100,
+	"rate_limit.write_rate":        2,
+	"rate_limit.write_burst":       30,
+	"rate_limit.address_rate":      50,
+	"rate_limit.address_burst":     200,
+	"idempotency.retention":        "24h",
+	"idempotency.lock_timeout":     "1m",
+	"cache.enabled":                true,
+	"cache.size":                   10000,
+	"cache.ttl":                    "1m",
+	"log.level":                    "info",
+	"log.format":                   "text",
+}
+
+// environment variables used before config sections were introduced, they are still accepted
+var legacyEnv = map[string]string{
+	"database.host":               "POSTGRES_HOST",
+	"database.user":               "POSTGRES_USER",
+	"database.password":           "POSTGRES_PASSWORD",
+	"database.name":               "POSTGRES_DB",
+	"server.ui_dir":               "UI_DIR",
+	"customers.deleted_retention": "DELETED_CUSTOMERS_RETENTION",
+	"customers.purge_interval":    "PURGE_INTERVAL",
+}
+
+// Invalid configuration, every bad key is listed
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+func newViper() (*viper.Viper, error) {
+	v := viper.New()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	for key, env := range legacyEnv {
+		if err := v.BindEnv(key, strings.ToUpper(strings.ReplaceAll(key, ".", "_")), env); err != nil {
+			return nil, err
+		}
+	}
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("cannot read config file %s: %w", file, err)
+		}
+		return v, nil
+	}
+	v.AddConfigPath("./resources/")
+	v.SetConfigName("app")
+	// config file is optional, everything can be set with environment variables
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// Read and validate configuration
+func Load() (*Config, error) {
+	v, err := newViper()
+	if err != nil {
+		return nil, err
+	}
 	conf := &Config{}
-	viper.AddConfigPath("./resources/")
-	viper.SetConfigName("app")
-	viper.SetConfigType("env")
-	viper.AutomaticEnv()
-	if err := viper.ReadInConfig(); err != nil {
-		panic("cannot read config from app.env file " + err.Error())
+	if err := v.Unmarshal(conf); err != nil {
+		// all keys of wrong type are listed in one error
+		return nil, &ValidationError{Problems: []string{err.Error()}}
 	}
-	if err := viper.Unmarshal(conf); err != nil {
-		panic("error unmarsha config")
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Configuration with default values only
+func Default() *Config {
+	v := viper.New()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+	conf := &Config{}
+	if err := v.Unmarshal(conf); err != nil {
+		panic(err)
 	}
 	return conf
 }
+
+var sslModes = map[string]bool{"disable": true, "allow": true, "prefer": true, "require": true, "verify-ca": true, "verify-full": true}
+
+func (c *Config) Validate() error {
+	problems := []string{}
+	check := func(ok bool, key, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	s := c.Server
+	check(s.Address != "", "server.address", "must not be empty")
+	check(s.ReadTimeout >= 0, "server.read_timeout", "must not be negative")
+	check(s.ReadHeaderTimeout >= 0, "server.read_header_timeout", "must not be negative")
+	check(s.WriteTimeout >= 0, "server.write_timeout", "must not be negative")
+	check(s.IdleTimeout >= 0, "server.idle_timeout", "must not be negative")
+	check(s.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+	check((s.TlsCertFile == "") == (s.TlsKeyFile == ""), "server.tls_cert_file", "must be set together with server.tls_key_file")
+	check(s.MaxBodySize > 0, "server.max_body_size", "must be positive")
+	check(s.MaxImportSize > 0, "server.max_import_size", "must be positive")
+	d := c.Database
+	if d.Dsn != "" {
+		// migrations don't accept key value form
+		check(strings.HasPrefix(d.Dsn, "postgres://") || strings.HasPrefix(d.Dsn, "postgresql://"), "database.dsn", "must be postgres:// url")
+	} else {
+		check(d.Host != "", "database.host", "must not be empty")
+		check(d.User != "", "database.user", "must not be empty")
+		check(d.Name != "", "database.name", "must not be empty")
+		check(sslModes[d.SslMode], "database.sslmode", "unknown mode %q", d.SslMode)
+	}
+	check(d.MaxOpenConns >= 0, "database.max_open_conns", "must not be negative")
+	check(d.MaxIdleConns >= 0, "database.max_idle_conns", "must not be negative")
+	check(d.ConnMaxLifetime >= 0, "database.conn_max_lifetime", "must not be negative")
+	check(c.Pagination.PageSize > 0 && c.Pagination.PageSize <= 1000, "pagination.page_size", "must be between 1 and 1000")
+	cu := c.Customers
+	check(cu.MinAge >= 0, "customers.min_age", "must not be negative")
+	check(cu.MaxAge >= cu.MinAge, "customers.max_age", "must not be less than customers.min_age")
+	check(len(cu.Genders) != 0, "customers.genders", "at least one gender must be allowed")
+	for _, gender := range cu.Genders {
+		check(gender != "" && gender == strings.ToLower(strings.TrimSpace(gender)) && len(gender) <= custval.ColumnGenderLength,
+			"customers.genders", "%q must be non empty lower case value up to %d characters", gender, custval.ColumnGenderLength)
+	}
+	check(cu.MaxNameLength > 0 && cu.MaxNameLength <= custval.ColumnNameLength, "customers.max_name_length", "must be between 1 and %d", custval.ColumnNameLength)
+	check(cu.MaxEmailLength > 0 && cu.MaxEmailLength <= custval.ColumnEmailLength, "customers.max_email_length", "must be between 1 and %d", custval.ColumnEmailLength)
+	check(cu.MaxAddressLength > 0 && cu.MaxAddressLength <= custval.ColumnAddressLength, "customers.max_address_length", "must be between 1 and %d", custval.ColumnAddressLength)
+	check(cu.DeletedRetention >= 0, "customers.deleted_retention", "must not be negative")
+	check(cu.PurgeInterval >= 0, "customers.purge_interval", "must not be negative")
+	check(c.Auth.SessionLifetime > 0, "auth.session_lifetime", "must be positive")
+	if rl := c.RateLimit; rl.Enabled {
+		check(rl.Rate > 0, "rate_limit.rate", "must be positive")
+		check(rl.Burst > 0, "rate_limit.burst", "must be positive")
+		check(rl.WriteRate > 0, "rate_limit.write_rate", "must be positive")
+		check(rl.WriteBurst > 0, "rate_limit.write_burst", "must be positive")
+		check(rl.AddressRate > 0, "rate_limit.address_rate", "must be positive")
+		check(rl.AddressBurst > 0, "rate_limit.address_burst", "must be positive")
+	}
+	check(c.Idempotency.Retention > 0, "idempotency.retention", "must be positive")
+	check(c.Idempotency.LockTimeout > 0, "idempotency.lock_timeout", "must be positive")
+	if c.Cache.Enabled {
+		check(c.Cache.Size > 0, "cache.size", "must be positive")
+		check(c.Cache.Ttl > 0, "cache.ttl", "must be positive")
+	}
+	_, err := logrus.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level", "unknown level %q", c.Log.Level)
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format", "must be text or json")
+	if len(problems) != 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Customer validation rules of service, ui and database
+func (c *CustomersConfig) Rules() custval.Rules {
+	return custval.Rules{
+		Ages:             custval.AgeLimits{Min: c.MinAge, Max: c.MaxAge},
+		Genders:          c.Genders,
+		MaxNameLength:    c.MaxNameLength,
+		MaxEmailLength:   c.MaxEmailLength,
+		MaxAddressLength: c.MaxAddressLength,
+	}
+}
+
+// Postgres connection string
+func (d *DatabaseConfig) ConnectionString() string {
+	if d.Dsn != "" {
+		return d.Dsn
+	}
+	u := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     d.Host,
+		Path:     "/" + d.Name,
+		RawQuery: url.Values{"sslmode": {d.SslMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// Configure logger with level and format
+func (l *LogConfig) Apply(logger *logrus.Logger) {
+	level, err := logrus.ParseLevel(l.Level)
+	if err == nil {
+		logger.SetLevel(level)
+	}
+	if l.Format == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+}
+
+// the same mask as url.URL.Redacted uses
+const redacted = "xxxxx"
+
+var dsnPassword = regexp.MustCompile(`password=\S+`)
+
+// Effective configuration(defaults, file and environment) with redacted secrets, it's used by config print command
+func Settings() (map[string]interface{}, error) {
+	v, err := newViper()
+	if err != nil {
+		return nil, err
+	}
+	settings := v.AllSettings()
+	if database, ok := settings["database"].(map[string]interface{}); ok {
+		if password, _ := database["password"].(string); password != "" {
+			database["password"] = redacted
+		}
+		if dsn, _ := database["dsn"].(string); dsn != "" {
+			database["dsn"] = redactDsn(dsn)
+		}
+	}
+	return settings, nil
+}
+
+// password is hidden both in url and key value connection strings
+func redactDsn(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "password="+redacted)
+}
@@ -0,0 +1,119 @@
+package conf
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateListsEveryBadKey(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Address = ""
+	cfg.Server.TlsCertFile = "cert.pem"
+	cfg.Database.SslMode = "sometimes"
+	cfg.Pagination.PageSize = 0
+	cfg.Customers.MinAge = 70
+	cfg.Log.Format = "xml"
+	err := cfg.Validate()
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatal("validation error is expected", err)
+	}
+	keys := []string{"server.address", "server.tls_cert_file", "database.sslmode", "pagination.page_size", "customers.max_age", "log.format"}
+	if len(validationErr.Problems) != len(keys) {
+		t.Fatal("unexpected problems", validationErr.Problems)
+	}
+	for i, key := range keys {
+		if !strings.HasPrefix(validationErr.Problems[i], key+":") {
+			t.Errorf("problem %q must be about %s", validationErr.Problems[i], key)
+		}
+	}
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	cfg := Default()
+	cfg.RateLimit.WriteRate = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "rate_limit.write_rate") {
+		t.Error("zero rate must be rejected", err)
+	}
+	cfg.RateLimit.Enabled = false
+	if err := cfg.Validate(); err != nil {
+		t.Error("disabled limit isn't checked", err)
+	}
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	env := map[string]string{"SERVER_WRITE_TIMEOUT": "10s", "POSTGRES_HOST": "db:5432", "PAGINATION_PAGE_SIZE": "50"}
+	for key, value := range env {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.WriteTimeout != 10*time.Second || cfg.Database.Host != "db:5432" || cfg.Pagination.PageSize != 50 {
+		t.Error("environment is not applied", cfg.Server.WriteTimeout, cfg.Database.Host, cfg.Pagination.PageSize)
+	}
+	if cfg.Server.Address != ":10001" {
+		t.Error("default is not applied", cfg.Server.Address)
+	}
+}
+
+func TestLoadRejectsWrongType(t *testing.T) {
+	os.Setenv("SERVER_READ_TIMEOUT", "soon")
+	defer os.Unsetenv("SERVER_READ_TIMEOUT")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "read_timeout") {
+		t.Error("wrong duration must be rejected", err)
+	}
+}
+
+func TestConnectionString(t *testing.T) {
+	d := DatabaseConfig{Host: "db:5432", User: "app", Password: "p@ss", Name: "customers", SslMode: "require"}
+	if got := d.ConnectionString(); got != "postgresql://app:p%40ss@db:5432/customers?sslmode=require" {
+		t.Error("unexpected connection string", got)
+	}
+	d.Dsn = "postgres://other/db"
+	if got := d.ConnectionString(); got != d.Dsn {
+		t.Error("dsn must be used as is", got)
+	}
+}
+
+func TestRedactDsn(t *testing.T) {
+	tt := map[string]string{
+		"postgres://app:secret@db/customers":        "postgres://app:xxxxx@db/customers",
+		"host=db user=app password=secret dbname=x": "host=db user=app password=xxxxx dbname=x",
+		"postgres://db/customers":                   "postgres://db/customers",
+	}
+	for dsn, want := range tt {
+		if got := redactDsn(dsn); got != want {
+			t.Errorf("redactDsn(%q) = %q, want %q", dsn, got, want)
+		}
+	}
+}
+
+func TestValidateCustomerRules(t *testing.T) {
+	cfg := Default()
+	cfg.Customers.Genders = []string{"female", "Other"}
+	cfg.Customers.MaxNameLength = 200
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), `customers.genders: "Other"`) || !strings.Contains(err.Error(), "customers.max_name_length") {
+		t.Error("rules wider than database must be rejected", err)
+	}
+	os.Setenv("CUSTOMERS_GENDERS", "female,male,other")
+	defer os.Unsetenv("CUSTOMERS_GENDERS")
+	loaded, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := loaded.Customers.Rules(); !rules.IsAllowedGender("other") || rules.IsAllowedGender("unspecified") {
+		t.Error("genders are not read from environment", rules.Genders)
+	}
+}
@@ -1,62 +1,167 @@
 package db
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"time"
+	"os"
+	"strconv"
 
 	"github.com/abdybaevae/customers-app/conf"
-	customerservice "github.com/abdybaevae/customers-app/pkg/services/customer"
-	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
-	"github.com/brianvoe/gofakeit/v6"
+	"github.com/abdybaevae/customers-app/resources"
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 )
 
+// migrations are embedded into binary
+func migrationsSource() (source.Driver, error) {
+	return iofs.New(resources.Migrations, "db/migrations")
+}
+
 func Connect(cfg *conf.Config) *sqlx.DB {
-	var connStr = fmt.Sprintf("postgresql://%s:%s@%s/%s?sslmode=disable", cfg.DbUser, cfg.DbPassword, cfg.DbHost, cfg.DbName)
-	return sqlx.MustConnect("postgres", connStr)
+	db := sqlx.MustConnect("postgres", cfg.Database.ConnectionString())
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+	return db
 }
-func HandleMigrations(cfg *conf.Config, customerService customerservice.CustomerService, db *sqlx.DB) error {
-	log := logrus.New()
-	log.Infof("start migrations")
-	m, err := migrate.New(
-		"file://resources/db/migrations",
-		fmt.Sprintf("postgresql://%s:%s@%s/%s?sslmode=disable", cfg.DbUser, cfg.DbPassword, cfg.DbHost, cfg.DbName))
+
+func newMigrate(cfg *conf.Config) (*migrate.Migrate, error) {
+	driver, err := migrationsSource()
 	if err != nil {
+		return nil, err
+	}
+	// migrate accepts only url form of connection string
+	return migrate.NewWithSourceInstance("iofs", driver, cfg.Database.ConnectionString())
+}
+
+// Version of the last migration file, schema of this version is expected by application
+func ExpectedVersion() (uint, error) {
+	driver, err := migrationsSource()
+	if err != nil {
+		return 0, err
+	}
+	defer driver.Close()
+	version, err := driver.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := driver.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// Application can't work with dirty schema(some migration failed in the middle) or with schema older than expected.
+// Newer schema is accepted, so previous version of application can run during deploy of new one.
+func CheckMigrations(cfg *conf.Config) error {
+	expected, err := ExpectedVersion()
+	if err != nil {
+		return err
+	}
+	m, err := newMigrate(cfg)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	version, dirty, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
 		return err
 	}
-	if err := m.Steps(1); err != nil && err != migrate.ErrNoChange {
-		log.Errorf("migration error %v", err)
+	return checkVersion(version, dirty, expected)
+}
+
+func checkVersion(version uint, dirty bool, expected uint) error {
+	if dirty {
+		return fmt.Errorf("database schema is dirty at version %d, fix it and run migrate force", version)
+	}
+	if version < expected {
+		return fmt.Errorf("database schema version %d is behind expected version %d, run migrate up", version, expected)
 	}
-	var count int
+	return nil
+}
 
-	if err := db.QueryRow("select count(*) from customers").Scan(&count); err != nil {
+// Run migrate command:
+//
+//	up          apply all pending migrations
+//	down N      revert N last migrations
+//	goto V      migrate up or down to version V
+//	version     print current version
+//	force V     set version V without running migrations and clear dirty flag(after manual fix of failed migration),
+//	            -1 means no migrations are applied(after failure of the first migration)
+func Migrate(cfg *conf.Config, args []string, log *logrus.Entry) error {
+	if len(args) == 0 {
+		return errors.New("migrate command is required: up, down N, goto V, version or force V")
+	}
+	m, err := newMigrate(cfg)
+	if err != nil {
 		return err
 	}
-	log.Infof("count of customer is %v", count)
-	if count == 0 {
-		log.Info("Create fake customers...")
-		for i := 0; i < 1000; i++ {
-			from, to := time.Now().AddDate(-59, 0, 0), time.Now().AddDate(-20, 0, 0)
-			if err := customerService.Create(context.Background(), &dto.CreateCustomerArguments{
-				CustomerItem: dto.CustomerItem{
-					FirstName: gofakeit.Person().FirstName,
-					LastName:  gofakeit.Person().LastName,
-					Gender:    gofakeit.Person().Gender,
-					BirthDate: gofakeit.DateRange(from, to),
-					Email:     gofakeit.Email(),
-					Address:   gofakeit.Person().Address.Address,
-				},
-			}); err != nil {
-				log.Errorf("error or creating customer %v", err)
-				return err
+	defer m.Close()
+	command, args := args[0], args[1:]
+	switch command {
+	case "up":
+		err = m.Up()
+	case "down":
+		var steps int
+		if steps, err = numberArg(args, 0); err == nil {
+			if steps <= 0 {
+				return errors.New("count of reverted migrations must be positive")
 			}
+			err = m.Steps(-steps)
+		}
+	case "goto":
+		var version int
+		if version, err = numberArg(args, 0); err == nil {
+			err = m.Migrate(uint(version))
+		}
+	case "force":
+		var version int
+		if version, err = numberArg(args, database.NilVersion); err == nil {
+			err = m.Force(version)
 		}
+	case "version":
+	default:
+		return fmt.Errorf("unknown migrate command %q", command)
 	}
+	if err == migrate.ErrNoChange {
+		log.Info("no migrations to apply")
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	version, dirty, err := m.Version()
+	if err == migrate.ErrNilVersion {
+		log.Info("database has no migrations applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Infof("database schema version %d, dirty %v", version, dirty)
 	return nil
 }
+
+// single number argument of command, it can't be less than min
+func numberArg(args []string, min int) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("command requires one number argument")
+	}
+	number, err := strconv.Atoi(args[0])
+	if err != nil || number < min {
+		return 0, fmt.Errorf("invalid number %q", args[0])
+	}
+	return number, nil
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/abdybaevae/customers-app/pkg/custval"
+	"github.com/abdybaevae/customers-app/resources"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+func TestExpectedVersion(t *testing.T) {
+	version, err := ExpectedVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := fs.Glob(resources.Migrations, "db/migrations/*.up.sql")
+	if int(version) != len(files) {
+		t.Error("expected version must be the last migration", version)
+	}
+}
+
+func TestNumberArg(t *testing.T) {
+	if n, err := numberArg([]string{"3"}, 0); err != nil || n != 3 {
+		t.Error("unexpected number", n, err)
+	}
+	for _, args := range [][]string{{}, {"-1"}, {"a"}, {"1", "2"}} {
+		if _, err := numberArg(args, 0); err == nil {
+			t.Error("invalid argument must be rejected", args)
+		}
+	}
+	// force -1 clears dirty state of failed first migration
+	if n, err := numberArg([]string{"-1"}, -1); err != nil || n != -1 {
+		t.Error("version without migrations must be accepted by force", n, err)
+	}
+	if _, err := numberArg([]string{"-2"}, -1); err == nil {
+		t.Error("number below min must be rejected")
+	}
+}
+
+func TestSaveCustomerRules(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rules := custval.DefaultRules()
+	mock.ExpectExec("insert into customer_rules").
+		WithArgs(18, 60, pq.Array(rules.Genders), 100, 150, 300).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := SaveCustomerRules(context.Background(), sqlx.NewDb(conn, "postgres"), rules); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReady(t *testing.T) {
+	expected, err := ExpectedVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt := []struct {
+		name    string
+		version uint
+		dirty   bool
+		ready   bool
+	}{
+		{"expected version", expected, false, true},
+		{"dirty schema", expected, true, false},
+		{"old schema", expected - 1, false, false},
+	}
+	for _, tc := range tt {
+		conn, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.ExpectPing()
+		mock.ExpectQuery("select version, dirty from schema_migrations").
+			WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(tc.version, tc.dirty))
+		err = Ready(context.Background(), sqlx.NewDb(conn, "postgres"))
+		if (err == nil) != tc.ready {
+			t.Errorf("%s: unexpected readiness %v", tc.name, err)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/abdybaevae/customers-app/pkg/metrics"
+	"github.com/jmoiron/sqlx"
+)
+
+// golang-migrate keeps applied version in this table
+const schemaVersionQuery = `select version, dirty from schema_migrations limit 1`
+
+// Readiness check: database answers and its schema has expected version(the same rules as on start).
+// Connection of application pool is used, so check is cheap enough for every probe.
+func Ready(ctx context.Context, db *sqlx.DB) error {
+	if err := db.PingContext(ctx); err != nil {
+		return err
+	}
+	expected, err := ExpectedVersion()
+	if err != nil {
+		return err
+	}
+	var version uint
+	var dirty bool
+	err = db.QueryRowContext(ctx, schemaVersionQuery).Scan(&version, &dirty)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	return checkVersion(version, dirty, expected)
+}
+
+// Connection pool stats are read on every scrape
+func RegisterMetrics(registry *metrics.Registry, db *sqlx.DB) {
+	stat := func(value func(s sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			return value(db.Stats())
+		}
+	}
+	registry.GaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	registry.GaugeFunc("db_open_connections", "The number of established connections both in use and idle.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	registry.GaugeFunc("db_in_use_connections", "The number of connections currently in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	registry.GaugeFunc("db_idle_connections", "The number of idle connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	registry.CounterFunc("db_wait_count_total", "The total number of connections waited for.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	registry.CounterFunc("db_wait_duration_seconds_total", "The total time blocked waiting for a new connection.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	registry.CounterFunc("db_max_idle_closed_total", "The total number of connections closed due to max idle connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	registry.CounterFunc("db_max_idle_time_closed_total", "The total number of connections closed due to max idle time.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	registry.CounterFunc("db_max_lifetime_closed_total", "The total number of connections closed due to max connection lifetime.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
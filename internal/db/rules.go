@@ -0,0 +1,28 @@
+package db
+
+import (
+	"context"
+
+	"github.com/abdybaevae/customers-app/pkg/custval"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const saveRulesQuery = `
+insert into customer_rules (rules_id, rules_min_age, rules_max_age, rules_genders, rules_max_name_length, rules_max_email_length, rules_max_address_length)
+values (true, $1, $2, $3, $4, $5, $6)
+on conflict (rules_id) do update set
+	rules_min_age = excluded.rules_min_age,
+	rules_max_age = excluded.rules_max_age,
+	rules_genders = excluded.rules_genders,
+	rules_max_name_length = excluded.rules_max_name_length,
+	rules_max_email_length = excluded.rules_max_email_length,
+	rules_max_address_length = excluded.rules_max_address_length`
+
+// Store configured customer rules, database checks written customers with them.
+// All servers of one database must have the same rules, the last started one wins.
+func SaveCustomerRules(ctx context.Context, db *sqlx.DB, rules custval.Rules) error {
+	_, err := db.ExecContext(ctx, saveRulesQuery, rules.Ages.Min, rules.Ages.Max, pq.Array(rules.Genders),
+		rules.MaxNameLength, rules.MaxEmailLength, rules.MaxAddressLength)
+	return err
+}
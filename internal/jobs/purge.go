@@ -0,0 +1,66 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	customerservice "github.com/abdybaevae/customers-app/pkg/services/customer"
+	idempotencyservice "github.com/abdybaevae/customers-app/pkg/services/idempotency"
+	userservice "github.com/abdybaevae/customers-app/pkg/services/user"
+	"github.com/sirupsen/logrus"
+)
+
+// Periodically remove customers that were deleted more than retention ago.
+// Function blocks till context is cancelled, so it's expected to be run in separate goroutine.
+func RunPurge(ctx context.Context, customerService customerservice.CustomerService, retention, interval time.Duration, log *logrus.Entry) {
+	if interval <= 0 {
+		log.Warnf("purge of deleted customers is disabled, interval %v", interval)
+		return
+	}
+	runEvery(ctx, interval, func() {
+		count, err := customerService.Purge(ctx, retention)
+		if err != nil {
+			log.Errorf("purge of deleted customers failed %v", err)
+		} else if count > 0 {
+			log.Infof("%d deleted customers were purged", count)
+		}
+	})
+}
+
+// Periodically remove expired user sessions, it blocks till context is cancelled like RunPurge.
+func RunSessionPurge(ctx context.Context, userService userservice.UserService, interval time.Duration, log *logrus.Entry) {
+	runEvery(ctx, interval, func() {
+		count, err := userService.PurgeSessions(ctx)
+		if err != nil {
+			log.Errorf("purge of expired sessions failed %v", err)
+		} else if count > 0 {
+			log.Debugf("%d expired sessions were purged", count)
+		}
+	})
+}
+
+// Periodically remove expired idempotency keys, it blocks till context is cancelled like RunPurge.
+func RunIdempotencyPurge(ctx context.Context, idempotencyService idempotencyservice.IdempotencyService, interval time.Duration, log *logrus.Entry) {
+	runEvery(ctx, interval, func() {
+		count, err := idempotencyService.Purge(ctx)
+		if err != nil {
+			log.Errorf("purge of expired idempotency keys failed %v", err)
+		} else if count > 0 {
+			log.Debugf("%d expired idempotency keys were purged", count)
+		}
+	})
+}
+
+// run job immediately and then every interval till context is cancelled
+func runEvery(ctx context.Context, interval time.Duration, job func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package seed
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/custval"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	customerrepo "github.com/abdybaevae/customers-app/pkg/repos/customer"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/sirupsen/logrus"
+)
+
+// audit actor of seeded customers
+const Actor = "seed"
+
+type Options struct {
+	// count of created customers
+	Count int
+	// random seed, the same seed generates the same customers(birthdates are relative to current date)
+	Seed int64
+	// customers stored in one transaction
+	BatchSize int
+	// generated customers satisfy rules, default rules are used if no gender is allowed
+	Rules custval.Rules
+}
+
+// Insert fake customers by batches, every batch is committed separately. Emails include sequence number of customer,
+// so they are unique within one run, rerun with the same seed is rejected with taken emails.
+func Run(ctx context.Context, customerRepo customerrepo.CustomerRepo, opts Options, log *logrus.Entry) error {
+	if opts.Count < 0 || opts.BatchSize <= 0 {
+		return fmt.Errorf("count must not be negative and batch size must be positive, got %d and %d", opts.Count, opts.BatchSize)
+	}
+	ctx = reqinfo.WithActor(ctx, Actor)
+	faker := gofakeit.New(opts.Seed)
+	rules := opts.Rules
+	if len(rules.Genders) == 0 {
+		rules = custval.DefaultRules()
+	}
+	// birthdates are inside of allowed age range
+	from, to := time.Now().AddDate(-rules.Ages.Max, 0, 1), time.Now().AddDate(-rules.Ages.Min, 0, 0)
+	for created := 0; created < opts.Count; {
+		size := opts.BatchSize
+		if opts.Count-created < size {
+			size = opts.Count - created
+		}
+		batch := make([]*models.Customer, 0, size)
+		for i := 0; i < size; i++ {
+			person := faker.Person()
+			gender := person.Gender
+			if !rules.IsAllowedGender(gender) {
+				gender = rules.Genders[faker.Number(0, len(rules.Genders)-1)]
+			}
+			batch = append(batch, &models.Customer{
+				FirstName: truncate(person.FirstName, rules.MaxNameLength),
+				LastName:  truncate(person.LastName, rules.MaxNameLength),
+				Gender:    gender,
+				BirthDate: faker.DateRange(from, to),
+				Email:     strings.ToLower(fmt.Sprintf("%s.%s.%d@%s", person.FirstName, person.LastName, created+i+1, faker.DomainName())),
+				Address:   truncate(person.Address.Address, rules.MaxAddressLength),
+				Hash:      utils.GenCustomerHash(),
+			})
+		}
+		if err := customerRepo.CreateMany(ctx, batch); err != nil {
+			return fmt.Errorf("batch of customers %d-%d wasn't stored: %w", created+1, created+size, err)
+		}
+		created += size
+		log.Infof("%d of %d customers were created", created, opts.Count)
+	}
+	return nil
+}
+
+// fake values are cut to configured length
+func truncate(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) > max {
+		return string(runes[:max])
+	}
+	return value
+}
@@ -0,0 +1,67 @@
+package seed
+
+import (
+	"context"
+	"testing"
+
+	"github.com/abdybaevae/customers-app/pkg/custval"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	customerrepo "github.com/abdybaevae/customers-app/pkg/repos/customer"
+	"github.com/sirupsen/logrus"
+)
+
+type batchRepo struct {
+	customerrepo.CustomerRepo
+	batches [][]*models.Customer
+}
+
+func (r *batchRepo) CreateMany(ctx context.Context, customers []*models.Customer) error {
+	r.batches = append(r.batches, customers)
+	return nil
+}
+
+func TestRunByBatches(t *testing.T) {
+	log := logrus.NewEntry(logrus.New())
+	first := &batchRepo{}
+	if err := Run(context.Background(), first, Options{Count: 25, Seed: 7, BatchSize: 10}, log); err != nil {
+		t.Fatal(err)
+	}
+	if len(first.batches) != 3 || len(first.batches[0]) != 10 || len(first.batches[2]) != 5 {
+		t.Fatal("unexpected batches", len(first.batches))
+	}
+	second := &batchRepo{}
+	Run(context.Background(), second, Options{Count: 25, Seed: 7, BatchSize: 25}, log)
+	if second.batches[0][24].Email != first.batches[2][4].Email || second.batches[0][0].FirstName != first.batches[0][0].FirstName {
+		t.Error("the same seed must generate the same customers")
+	}
+	if err := Run(context.Background(), second, Options{Count: 1, BatchSize: 0}, log); err == nil {
+		t.Error("zero batch size must be rejected")
+	}
+}
+
+func TestRunFollowsRules(t *testing.T) {
+	rules := custval.Rules{
+		Ages:             custval.AgeLimits{Min: 30, Max: 35},
+		Genders:          []string{"unspecified"},
+		MaxNameLength:    3,
+		MaxEmailLength:   150,
+		MaxAddressLength: 5,
+	}
+	repo := &batchRepo{}
+	if err := Run(context.Background(), repo, Options{Count: 50, Seed: 1, BatchSize: 50, Rules: rules}, logrus.NewEntry(logrus.New())); err != nil {
+		t.Fatal(err)
+	}
+	for _, customer := range repo.batches[0] {
+		err := rules.Check(custval.Fields{
+			FirstName: customer.FirstName,
+			LastName:  customer.LastName,
+			BirthDate: customer.BirthDate,
+			Gender:    customer.Gender,
+			Email:     customer.Email,
+			Address:   customer.Address,
+		})
+		if err != nil {
+			t.Fatal("seeded customer breaks rules", err)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/resp"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	"github.com/gorilla/mux"
+)
+
+// Json api works with the same customer service as html handlers, only data source(json body, query string)
+// and response format are different. Errors are reflected to json with codes.ErrorCode values.
+var apiRespFactory = resp.GetJsonResponseFactory()
+
+// Customer representation for api consumers.
+type apiCustomer struct {
+	Id        int       `json:"id"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	BirthDate string    `json:"birthDate"`
+	Gender    string    `json:"gender"`
+	Email     string    `json:"email"`
+	Address   string    `json:"address"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func toApiCustomer(customer *models.Customer) *apiCustomer {
+	return &apiCustomer{
+		Id:        customer.Id,
+		FirstName: customer.FirstName,
+		LastName:  customer.LastName,
+		BirthDate: customer.BirthDate.Format(birthDateLayout),
+		Gender:    customer.Gender,
+		Email:     customer.Email,
+		Address:   customer.Address,
+		Hash:      customer.Hash,
+		CreatedAt: customer.CreatedAt,
+		UpdatedAt: customer.UpdatedAt,
+	}
+}
+
+// Request body for create and update. Fields are pointers, so PATCH can tell missing field from empty one.
+type apiCustomerRequest struct {
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	BirthDate *string `json:"birthDate"`
+	Gender    *string `json:"gender"`
+	Email     *string `json:"email"`
+	Address   *string `json:"address"`
+	Hash      string  `json:"hash"`
+	// values client started editing from, they let server merge concurrent changes of different fields
+	Original *apiCustomerFields `json:"original"`
+}
+
+type apiCustomerFields struct {
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	BirthDate *string `json:"birthDate"`
+	Gender    *string `json:"gender"`
+	Email     *string `json:"email"`
+	Address   *string `json:"address"`
+}
+
+// update conflict in api representation
+type apiUpdateConflict struct {
+	Current *apiCustomer `json:"current"`
+	Fields  []string     `json:"fields"`
+}
+
+func registerApiRoutes(router *mux.Router, h *handler) {
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/customers", h.requireScope(models.ScopeCustomersRead, h.apiListCustomers)).Methods(http.MethodGet)
+	api.HandleFunc("/customers", h.requireScope(models.ScopeCustomersWrite, h.idempotent(h.apiCreateCustomer))).Methods(http.MethodPost)
+	api.HandleFunc("/customers/import", h.requireScope(models.ScopeCustomersWrite, h.apiImportCustomers)).Methods(http.MethodPost)
+	api.HandleFunc("/customers/{customerId}", h.requireScope(models.ScopeCustomersRead, h.apiGetCustomer)).Methods(http.MethodGet)
+	api.HandleFunc("/customers/{customerId}", h.requireScope(models.ScopeCustomersWrite, h.idempotent(h.apiUpdateCustomer))).Methods(http.MethodPut, http.MethodPatch)
+	api.HandleFunc("/customers/{customerId}", h.requireScope(models.ScopeCustomersDelete, h.idempotent(h.apiDeleteCustomer))).Methods(http.MethodDelete)
+	api.HandleFunc("/customers/{customerId}/history", h.requireScope(models.ScopeCustomersRead, h.apiCustomerHistory)).Methods(http.MethodGet)
+	api.HandleFunc("/customers/{customerId}/restore", h.requireScope(models.ScopeCustomersWrite, h.apiRestoreCustomer)).Methods(http.MethodPost)
+	api.NotFoundHandler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		apiRespFactory.CodeMessage(rw, codes.ResourceNotFound, codes.KnownMessageNotFoundPage)
+	})
+}
+
+func (h *handler) apiListCustomers(rw http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	args := &dto.ListCustomersArguments{
+		OrderBy:      query.Get("orderBy"),
+		OrderByValue: query.Get("orderByValue"),
+		SearchValue:  query.Get("searchValue"),
+		Cursor:       query.Get("cursor"),
+		WithTotal:    query.Get("withTotal") == "true",
+		Deleted:      query.Get("deleted") == "true",
+	}
+	if args.OrderBy == "" {
+		args.OrderBy = "customer_first_name"
+	}
+	if args.OrderByValue == "" {
+		args.OrderByValue = "asc"
+	}
+	data, err := h.customerService.QueryList(r.Context(), args)
+	if err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	resp.Json(rw, http.StatusOK, data)
+}
+
+func (h *handler) apiGetCustomer(rw http.ResponseWriter, r *http.Request) {
+	customerId, ok := apiCustomerId(rw, r)
+	if !ok {
+		return
+	}
+	customer, err := h.customerService.GetById(r.Context(), customerId)
+	if err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	resp.Json(rw, http.StatusOK, toApiCustomer(customer))
+}
+
+func (h *handler) apiCreateCustomer(rw http.ResponseWriter, r *http.Request) {
+	body, ok := decodeApiCustomerRequest(rw, r)
+	if !ok {
+		return
+	}
+	birthDate, err := parseApiBirthDate(body.BirthDate)
+	if err != nil {
+		apiRespFactory.CodeMessage(rw, codes.InvalidData, codes.KnownMessageCustomerInvalidBirthDate)
+		return
+	}
+	customerId, err := h.customerService.Create(r.Context(), &dto.CreateCustomerArguments{
+		CustomerItem: dto.CustomerItem{
+			FirstName: stringValue(body.FirstName),
+			LastName:  stringValue(body.LastName),
+			BirthDate: birthDate,
+			Gender:    stringValue(body.Gender),
+			Email:     stringValue(body.Email),
+			Address:   stringValue(body.Address),
+		},
+	})
+	if err == codes.UniqueConstraintViolation {
+		apiRespFactory.CodeMessage(rw, codes.EmailTaken, codes.KnownMessageGivenEmailBusyUseAnotherOne)
+		return
+	}
+	if err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	customer, err := h.customerService.GetById(r.Context(), customerId)
+	if err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	rw.Header().Set("Location", "/api/v1/customers/"+strconv.Itoa(customerId))
+	resp.Json(rw, http.StatusCreated, toApiCustomer(customer))
+}
+
+// PUT replaces all editable fields, PATCH takes missing fields from current customer state.
+// Both of them require hash that client received with customer, so concurrent edits are still rejected.
+func (h *handler) apiUpdateCustomer(rw http.ResponseWriter, r *http.Request) {
+	customerId, ok := apiCustomerId(rw, r)
+	if !ok {
+		return
+	}
+	body, ok := decodeApiCustomerRequest(rw, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodPatch {
+		current, err := h.customerService.GetById(r.Context(), customerId)
+		if err != nil {
+			apiRespFactory.Error(rw, err)
+			return
+		}
+		fillMissingFields(body, current)
+	}
+	birthDate, err := parseApiBirthDate(body.BirthDate)
+	if err != nil {
+		apiRespFactory.CodeMessage(rw, codes.InvalidData, codes.KnownMessageCustomerInvalidBirthDate)
+		return
+	}
+	editArgs := &dto.UpdateCustomerArguments{
+		Id:        customerId,
+		FirstName: stringValue(body.FirstName),
+		LastName:  stringValue(body.LastName),
+		Gender:    stringValue(body.Gender),
+		BirthDate: birthDate,
+		Email:     stringValue(body.Email),
+		Address:   stringValue(body.Address),
+		Hash:      body.Hash,
+	}
+	if body.Original != nil {
+		original, err := originalFields(body)
+		if err != nil {
+			apiRespFactory.CodeMessage(rw, codes.InvalidData, codes.KnownMessageCustomerInvalidBirthDate)
+			return
+		}
+		editArgs.Original = original
+	}
+	if err := h.customerService.Update(r.Context(), editArgs); err != nil {
+		// conflicting customer is returned in api representation
+		if dataErr, ok := err.(codes.DataErrorCode); ok {
+			if conflict, ok := dataErr.Data().(*dto.UpdateConflict); ok {
+				err = codes.NewErrWithData(dataErr.Code(), dataErr.Message(), &apiUpdateConflict{
+					Current: toApiCustomer(conflict.Current),
+					Fields:  conflict.Fields,
+				})
+			}
+		}
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	customer, err := h.customerService.GetById(r.Context(), customerId)
+	if err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	resp.Json(rw, http.StatusOK, toApiCustomer(customer))
+}
+
+func (h *handler) apiDeleteCustomer(rw http.ResponseWriter, r *http.Request) {
+	customerId, ok := apiCustomerId(rw, r)
+	if !ok {
+		return
+	}
+	if err := h.customerService.DeleteById(r.Context(), customerId); err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	apiRespFactory.CodeMessage(rw, codes.Ok, codes.KnownMessageCustomerDeleted)
+}
+
+func (h *handler) apiRestoreCustomer(rw http.ResponseWriter, r *http.Request) {
+	customerId, ok := apiCustomerId(rw, r)
+	if !ok {
+		return
+	}
+	if err := h.customerService.Restore(r.Context(), customerId); err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	customer, err := h.customerService.GetById(r.Context(), customerId)
+	if err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	resp.Json(rw, http.StatusOK, toApiCustomer(customer))
+}
+
+func (h *handler) apiCustomerHistory(rw http.ResponseWriter, r *http.Request) {
+	customerId, ok := apiCustomerId(rw, r)
+	if !ok {
+		return
+	}
+	history, err := h.customerService.History(r.Context(), customerId)
+	if err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	resp.Json(rw, http.StatusOK, history)
+}
+
+// read customer id from path, responds with not found if id is malformed
+func apiCustomerId(rw http.ResponseWriter, r *http.Request) (int, bool) {
+	customerId, err := strconv.Atoi(mux.Vars(r)["customerId"])
+	if err != nil {
+		apiRespFactory.CodeMessage(rw, codes.CustomerNotFound, codes.KnownCustomerNotFound)
+		return 0, false
+	}
+	return customerId, true
+}
+
+func decodeApiCustomerRequest(rw http.ResponseWriter, r *http.Request) (*apiCustomerRequest, bool) {
+	body := &apiCustomerRequest{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		apiRespFactory.CodeMessage(rw, codes.BadRequest, codes.KnownMessageBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+func fillMissingFields(body *apiCustomerRequest, current *models.Customer) {
+	if body.FirstName == nil {
+		body.FirstName = &current.FirstName
+	}
+	if body.LastName == nil {
+		body.LastName = &current.LastName
+	}
+	if body.Gender == nil {
+		body.Gender = &current.Gender
+	}
+	if body.Email == nil {
+		body.Email = &current.Email
+	}
+	if body.Address == nil {
+		body.Address = &current.Address
+	}
+	if body.BirthDate == nil {
+		birthDate := current.BirthDate.Format(birthDateLayout)
+		body.BirthDate = &birthDate
+	}
+}
+
+// original values, missing ones are considered unchanged by client(the same as sent values)
+func originalFields(body *apiCustomerRequest) (*dto.CustomerFields, error) {
+	original := body.Original
+	if original.FirstName == nil {
+		original.FirstName = body.FirstName
+	}
+	if original.LastName == nil {
+		original.LastName = body.LastName
+	}
+	if original.Gender == nil {
+		original.Gender = body.Gender
+	}
+	if original.Email == nil {
+		original.Email = body.Email
+	}
+	if original.Address == nil {
+		original.Address = body.Address
+	}
+	if original.BirthDate == nil {
+		original.BirthDate = body.BirthDate
+	}
+	birthDate, err := parseApiBirthDate(original.BirthDate)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.CustomerFields{
+		FirstName: stringValue(original.FirstName),
+		LastName:  stringValue(original.LastName),
+		BirthDate: birthDate,
+		Gender:    stringValue(original.Gender),
+		Email:     stringValue(original.Email),
+		Address:   stringValue(original.Address),
+	}, nil
+}
+
+func parseApiBirthDate(value *string) (time.Time, error) {
+	return time.Parse(birthDateLayout, stringValue(value))
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	customerservice "github.com/abdybaevae/customers-app/pkg/services/customer"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// in memory customer service, enough to check handlers without database
+type fakeService struct {
+	customerservice.CustomerService
+	customers map[int]*models.Customer
+	updated   *dto.UpdateCustomerArguments
+}
+
+func newFakeService() *fakeService {
+	return &fakeService{customers: map[int]*models.Customer{}}
+}
+
+func (f *fakeService) Create(ctx context.Context, args *dto.CreateCustomerArguments) (int, error) {
+	for _, c := range f.customers {
+		if c.Email == args.Email {
+			return 0, codes.UniqueConstraintViolation
+		}
+	}
+	id := len(f.customers) + 1
+	f.customers[id] = &models.Customer{
+		Id:        id,
+		FirstName: args.FirstName,
+		LastName:  args.LastName,
+		BirthDate: args.BirthDate,
+		Gender:    args.Gender,
+		Email:     args.Email,
+		Address:   args.Address,
+	}
+	return id, nil
+}
+func (f *fakeService) DeleteById(ctx context.Context, customerId int) error {
+	if _, ok := f.customers[customerId]; !ok {
+		return codes.NewErr(codes.CustomerNotFound, codes.KnownCustomerNotFound)
+	}
+	delete(f.customers, customerId)
+	return nil
+}
+func (f *fakeService) Update(ctx context.Context, args *dto.UpdateCustomerArguments) error {
+	f.updated = args
+	if current, ok := f.customers[args.Id]; ok && current.Hash != "" && current.Hash != args.Hash {
+		return codes.NewErrWithData(codes.OverwriteData, codes.KnownMessageEditCustomerConflict, &dto.UpdateConflict{Current: current, Fields: []string{"lastName"}})
+	}
+	return nil
+}
+func (f *fakeService) QueryList(ctx context.Context, args *dto.ListCustomersArguments) (*dto.ListCustomersResult, error) {
+	res := &dto.ListCustomersResult{Customers: []dto.ListCustomerResultItem{}}
+	for _, c := range f.customers {
+		res.Customers = append(res.Customers, dto.ListCustomerResultItem{Id: c.Id, Email: c.Email})
+	}
+	return res, nil
+}
+func (f *fakeService) Export(ctx context.Context, args *dto.ExportCustomersArguments, each func(item *dto.ListCustomerResultItem) error) error {
+	for id := 1; id <= len(f.customers); id++ {
+		c := f.customers[id]
+		if err := each(&dto.ListCustomerResultItem{Id: c.Id, Email: c.Email, FirstName: c.FirstName, Address: c.Address}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (f *fakeService) GetById(ctx context.Context, customerId int) (*models.Customer, error) {
+	customer, ok := f.customers[customerId]
+	if !ok {
+		return nil, codes.NewErr(codes.CustomerNotFound, codes.KnownCustomerNotFound)
+	}
+	return customer, nil
+}
+
+func newApiRouter(service *fakeService) http.Handler {
+	router := mux.NewRouter()
+	registerApiRoutes(router, &handler{customerService: service, log: logrus.NewEntry(logrus.New())})
+	// api handlers are checked without sessions, requests are made by admin
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(rw, withUser(r, &models.User{Login: "admin", Role: models.RoleAdmin}))
+	})
+}
+
+func doRequest(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func TestApiCreateAndGet(t *testing.T) {
+	router := newApiRouter(newFakeService())
+	body := `{"firstName":"John","lastName":"Doe","birthDate":"1990-01-02","gender":"male","email":"john@doe.com"}`
+	rec := doRequest(router, http.MethodPost, "/api/v1/customers", body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Location") != "/api/v1/customers/1" {
+		t.Error("wrong location header", rec.Header().Get("Location"))
+	}
+	rec = doRequest(router, http.MethodGet, "/api/v1/customers/1", "")
+	created := &apiCustomer{}
+	if err := json.NewDecoder(rec.Body).Decode(created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Email != "john@doe.com" || created.BirthDate != "1990-01-02" {
+		t.Error("unexpected customer", created)
+	}
+}
+
+func TestApiErrorCodes(t *testing.T) {
+	service := newFakeService()
+	service.Create(context.Background(), &dto.CreateCustomerArguments{CustomerItem: dto.CustomerItem{Email: "john@doe.com"}})
+	router := newApiRouter(service)
+	type test struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   codes.Code
+	}
+	tt := []test{
+		{"missing customer", http.MethodGet, "/api/v1/customers/42", "", http.StatusNotFound, codes.CustomerNotFound},
+		{"malformed id", http.MethodDelete, "/api/v1/customers/abc", "", http.StatusNotFound, codes.CustomerNotFound},
+		{"malformed body", http.MethodPost, "/api/v1/customers", "{", http.StatusBadRequest, codes.BadRequest},
+		{"bad birthdate", http.MethodPost, "/api/v1/customers", `{"birthDate":"02.01.1990"}`, http.StatusBadRequest, codes.InvalidData},
+		{"email taken", http.MethodPost, "/api/v1/customers", `{"birthDate":"1990-01-02","email":"john@doe.com"}`, http.StatusBadRequest, codes.EmailTaken},
+		{"unknown resource", http.MethodGet, "/api/v1/orders", "", http.StatusNotFound, codes.ResourceNotFound},
+	}
+	for _, tc := range tt {
+		rec := doRequest(router, tc.method, tc.target, tc.body)
+		msg := &rsMessageBody{}
+		json.NewDecoder(rec.Body).Decode(msg)
+		if rec.Code != tc.status || msg.Code != string(tc.code) {
+			t.Errorf("%s: expected %d %s, got %d %s", tc.name, tc.status, tc.code, rec.Code, msg.Code)
+		}
+	}
+}
+
+func TestApiPatchKeepsMissingFields(t *testing.T) {
+	service := newFakeService()
+	router := newApiRouter(service)
+	doRequest(router, http.MethodPost, "/api/v1/customers", `{"firstName":"John","lastName":"Doe","birthDate":"1990-01-02","gender":"male","email":"john@doe.com","address":"Street"}`)
+	rec := doRequest(router, http.MethodPatch, "/api/v1/customers/1", `{"lastName":"Smith","hash":"abc"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	got := service.updated
+	if got.FirstName != "John" || got.LastName != "Smith" || got.Address != "Street" || got.Hash != "abc" {
+		t.Error("patch didn't merge fields", got)
+	}
+}
+
+func TestApiUpdateConflict(t *testing.T) {
+	service := newFakeService()
+	router := newApiRouter(service)
+	doRequest(router, http.MethodPost, "/api/v1/customers", `{"firstName":"John","lastName":"Doe","birthDate":"1990-01-02","gender":"male","email":"john@doe.com"}`)
+	service.customers[1].Hash = "current"
+	rec := doRequest(router, http.MethodPatch, "/api/v1/customers/1", `{"lastName":"Smith","hash":"stale"}`)
+	body := &struct {
+		rsMessageBody
+		Data apiUpdateConflict `json:"data"`
+	}{}
+	json.NewDecoder(rec.Body).Decode(body)
+	if rec.Code != http.StatusConflict || body.Code != string(codes.OverwriteData) {
+		t.Fatalf("expected conflict, got %d %s", rec.Code, body.Code)
+	}
+	if body.Data.Current.Hash != "current" || body.Data.Current.LastName != "Doe" || len(body.Data.Fields) != 1 {
+		t.Error("current customer wasn't returned with conflict", body.Data)
+	}
+}
+
+type rsMessageBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+)
+
+// Users log in with login form and receive session token in http only cookie. Every other request is authenticated
+// with it, user and his login(as actor of audit records) are put to request context. Machine clients use api
+// with api key in Authorization header instead of session. Every customer route requires scope: user has scopes
+// of his role and api key has scopes given on its creation.
+const sessionCookieName = "session"
+
+const bearerPrefix = "Bearer "
+
+type userContextKey struct{}
+type apiKeyContextKey struct{}
+
+// paths available without session, login page itself, probes and static files of login page
+func isPublicPath(path string) bool {
+	switch path {
+	case "/login", "/logout", "/healthz", "/readyz":
+		return true
+	}
+	return strings.HasPrefix(path, "/static/")
+}
+
+func isApiRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// authenticated user of request, nil for public paths
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey{}).(*models.User)
+	return user
+}
+
+// api key which request was authenticated with, nil for requests of users
+func currentApiKey(r *http.Request) *models.ApiKey {
+	apiKey, _ := r.Context().Value(apiKeyContextKey{}).(*models.ApiKey)
+	return apiKey
+}
+
+// api key from Authorization header, empty if request doesn't have it
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > len(bearerPrefix) && strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return strings.TrimSpace(header[len(bearerPrefix):])
+	}
+	return ""
+}
+
+func withUser(r *http.Request, user *models.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey{}, user)
+	ctx = reqinfo.WithActor(ctx, user.Login)
+	ctx = reqinfo.WithLogger(ctx, reqinfo.Logger(ctx, nil).WithField("user", user.Login))
+	return r.WithContext(ctx)
+}
+
+func withApiKey(r *http.Request, apiKey *models.ApiKey) *http.Request {
+	actor := "api-key:" + apiKey.Prefix
+	ctx := context.WithValue(r.Context(), apiKeyContextKey{}, apiKey)
+	ctx = reqinfo.WithActor(ctx, actor)
+	ctx = reqinfo.WithLogger(ctx, reqinfo.Logger(ctx, nil).WithField("api_key", apiKey.Prefix))
+	return r.WithContext(ctx)
+}
+
+// Router middleware, it runs after route is matched, so rejected requests are measured with their route too
+func (h *handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		// request with api key is never authenticated by session, it's exempt from csrf check
+		if key := bearerToken(r); key != "" {
+			h.authenticateApiKey(rw, r, key, next)
+			return
+		}
+		var token string
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			token = cookie.Value
+		}
+		user, err := h.userService.Authenticate(r.Context(), token)
+		if errCode, ok := err.(codes.ErrorCode); ok && errCode.Code() == codes.Unauthorized {
+			h.loginRequired(rw, r)
+			return
+		}
+		if err != nil {
+			if isApiRequest(r) {
+				apiRespFactory.Error(rw, err)
+			} else {
+				respFactory.Error(rw, err)
+			}
+			return
+		}
+		next.ServeHTTP(rw, withUser(r, user))
+	})
+}
+
+// api keys are accepted only by api and metrics(scraper has key with metrics:read scope), pages are for users
+func (h *handler) authenticateApiKey(rw http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	if !isApiRequest(r) && r.URL.Path != metricsPath {
+		respFactory.CodeMessage(rw, codes.Unauthorized, codes.KnownMessageLoginRequired)
+		return
+	}
+	apiKey, err := h.apiKeyService.Authenticate(r.Context(), key)
+	if errCode, ok := err.(codes.ErrorCode); ok && errCode.Code() == codes.Unauthorized {
+		rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	if err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	next.ServeHTTP(rw, withApiKey(r, apiKey))
+}
+
+// pages are redirected to login form which brings user back after login, other requests are just rejected
+func (h *handler) loginRequired(rw http.ResponseWriter, r *http.Request) {
+	if isApiRequest(r) || r.URL.Path == metricsPath {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		apiRespFactory.CodeMessage(rw, codes.Unauthorized, codes.KnownMessageLoginRequired)
+		return
+	}
+	if r.Method == http.MethodGet {
+		http.Redirect(rw, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	respFactory.CodeMessage(rw, codes.Unauthorized, codes.KnownMessageLoginRequired)
+}
+
+// Handler is allowed only for users which role grants given scope and for api keys with given scope
+func (h *handler) requireScope(scope models.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		allowed := false
+		if user := currentUser(r); user != nil {
+			allowed = user.Role.Grants(scope)
+		} else if apiKey := currentApiKey(r); apiKey != nil {
+			allowed = apiKey.HasScope(scope)
+		}
+		if !allowed {
+			if isApiRequest(r) {
+				apiRespFactory.CodeMessage(rw, codes.Forbidden, codes.KnownMessageForbidden)
+			} else {
+				respFactory.CodeMessage(rw, codes.Forbidden, codes.KnownMessageForbidden)
+			}
+			return
+		}
+		next(rw, r)
+	}
+}
+
+// Navigation and page actions are shown only if user is allowed to use them
+type navData struct {
+	User      *models.User
+	CanEdit   bool
+	CanDelete bool
+	// logout form token
+	CsrfToken string
+}
+
+func (h *handler) nav(r *http.Request) navData {
+	user := currentUser(r)
+	if user == nil {
+		return navData{}
+	}
+	return navData{
+		User:      user,
+		CanEdit:   user.Role.Grants(models.ScopeCustomersWrite),
+		CanDelete: user.Role.Grants(models.ScopeCustomersDelete),
+		CsrfToken: csrfToken(r),
+	}
+}
+
+type LoginPageData struct {
+	Login     string
+	Next      string
+	Error     string
+	CsrfToken string
+}
+
+func (h *handler) loginPage(rw http.ResponseWriter, r *http.Request) {
+	h.templates.ExecuteTemplate(rw, "login", &LoginPageData{
+		Next:      safeNext(r.URL.Query().Get("next")),
+		CsrfToken: csrfToken(r),
+	})
+}
+
+func (h *handler) handleLogin(rw http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respFactory.CodeMessage(rw, codes.BadRequest, codes.KnownMessageBadRequest)
+		return
+	}
+	login := r.PostForm.Get("login")
+	next := safeNext(r.PostForm.Get("next"))
+	token, user, err := h.userService.Login(r.Context(), login, r.PostForm.Get("password"))
+	if errCode, ok := err.(codes.ErrorCode); ok && errCode.Code() == codes.Unauthorized {
+		rw.WriteHeader(codes.StatusCode(codes.Unauthorized))
+		h.templates.ExecuteTemplate(rw, "login", &LoginPageData{
+			Login:     login,
+			Next:      next,
+			Error:     errCode.Message(),
+			CsrfToken: csrfToken(r),
+		})
+		return
+	}
+	if err != nil {
+		respFactory.Error(rw, err)
+		return
+	}
+	reqinfo.Logger(r.Context(), h.log).WithField("user", user.Login).Info("user logged in")
+	lifetime := h.Cfg.Auth.SessionLifetime
+	http.SetCookie(rw, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(lifetime),
+		MaxAge:   int(lifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || h.Cfg.Auth.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(rw, r, next, http.StatusSeeOther)
+}
+
+// logout is public, so expired session cookie can be removed too
+func (h *handler) handleLogout(rw http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := h.userService.Logout(r.Context(), cookie.Value); err != nil {
+			respFactory.Error(rw, err)
+			return
+		}
+	}
+	http.SetCookie(rw, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || h.Cfg.Auth.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(rw, r, "/login", http.StatusSeeOther)
+}
+
+// Page user is sent to after login, only local paths are accepted so login link can't redirect to another site.
+// Browsers drop tabs and newlines from urls and read backslash as slash, so "/\t/evil.com" would become "//evil.com".
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return "/"
+	}
+	for _, r := range next {
+		if r < 0x20 || r == 0x7f || r == '\\' {
+			return "/"
+		}
+	}
+	parsed, err := url.Parse(next)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.User != nil {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/abdybaevae/customers-app/conf"
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	apikeyservice "github.com/abdybaevae/customers-app/pkg/services/apikey"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	userservice "github.com/abdybaevae/customers-app/pkg/services/user"
+	"github.com/sirupsen/logrus"
+)
+
+// users with password "secret", session token of user is his login with "-token" suffix
+type fakeUserService struct {
+	userservice.UserService
+	users map[string]*models.User
+}
+
+func newFakeUserService() *fakeUserService {
+	return &fakeUserService{users: map[string]*models.User{
+		"viewer": {Id: 1, Login: "viewer", Role: models.RoleViewer},
+		"editor": {Id: 2, Login: "editor", Role: models.RoleEditor},
+		"admin":  {Id: 3, Login: "admin", Role: models.RoleAdmin},
+	}}
+}
+
+func (f *fakeUserService) Login(ctx context.Context, login, password string) (string, *models.User, error) {
+	user, ok := f.users[login]
+	if !ok || password != "secret" {
+		return "", nil, codes.NewErr(codes.Unauthorized, codes.KnownMessageInvalidCredentials)
+	}
+	return login + "-token", user, nil
+}
+
+func (f *fakeUserService) Authenticate(ctx context.Context, token string) (*models.User, error) {
+	user, ok := f.users[strings.TrimSuffix(token, "-token")]
+	if !ok {
+		return nil, codes.NewErr(codes.Unauthorized, codes.KnownMessageLoginRequired)
+	}
+	return user, nil
+}
+
+func (f *fakeUserService) Logout(ctx context.Context, token string) error {
+	return nil
+}
+
+// api keys are named by their scopes, e.g. "cak_customers:read"
+type fakeApiKeyService struct {
+	apikeyservice.ApiKeyService
+}
+
+func newFakeApiKeyService() *fakeApiKeyService {
+	return &fakeApiKeyService{}
+}
+
+func (f *fakeApiKeyService) Authenticate(ctx context.Context, key string) (*models.ApiKey, error) {
+	scope := models.Scope(strings.TrimPrefix(key, apikeyservice.KeyMarker))
+	if !scope.IsValid() {
+		return nil, codes.NewErr(codes.Unauthorized, codes.KnownMessageInvalidApiKey)
+	}
+	return &models.ApiKey{Prefix: "cak_1234", Scopes: []string{string(scope)}}, nil
+}
+
+func newAuthHandler() http.Handler {
+	service := newFakeService()
+	service.customers[1] = &models.Customer{Id: 1, FirstName: "John", Hash: "hash"}
+	return NewHandler(service, newFakeUserService(), newFakeApiKeyService(), conf.Default(), logrus.NewEntry(logrus.New()), Options{})
+}
+
+func TestLoginRequired(t *testing.T) {
+	h := newAuthHandler()
+	rec := doRequest(h, http.MethodGet, "/customers/deleted?cursor=x", "")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login?next="+url.QueryEscape("/customers/deleted?cursor=x") {
+		t.Error("page must redirect to login", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := doRequest(h, http.MethodGet, "/api/v1/customers/1", ""); rec.Code != http.StatusUnauthorized {
+		t.Error("api must reject request without session", rec.Code)
+	}
+	for _, path := range []string{"/login", "/healthz", "/static/jquery-3.6.0.min.js"} {
+		if rec := doRequest(h, http.MethodGet, path, ""); rec.Code != http.StatusOK {
+			t.Error("public path must be served without session", path, rec.Code)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	h := newAuthHandler()
+	csrfCookie, token := issueCsrf(t, h, "/login")
+	login := func(password, next string) *httptest.ResponseRecorder {
+		form := url.Values{"login": {"editor"}, "password": {password}, "next": {next}, CsrfFieldName: {token}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(csrfCookie)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := login("wrong", "/")
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), codes.KnownMessageInvalidCredentials) {
+		t.Error("wrong password must be rejected", rec.Code)
+	}
+	rec = login("secret", "/customers/deleted")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/customers/deleted" {
+		t.Fatal("user must be redirected to requested page", rec.Code, rec.Header().Get("Location"))
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookieName || cookies[0].Value != "editor-token" ||
+		!cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Error("session cookie wasn't set properly", cookies)
+	}
+	for _, next := range []string{"//evil.com", "https://evil.com", "/\\evil.com", "/\t/evil.com", "/\n/evil.com", "/\r\n/evil.com", "/x\\..\\\\evil.com"} {
+		if rec := login("secret", next); rec.Header().Get("Location") != "/" {
+			t.Error("login must not redirect to another site", next, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRoleGating(t *testing.T) {
+	h := newAuthHandler()
+	csrfCookie, token := issueCsrf(t, h, "/login")
+	tt := []struct {
+		user   string
+		method string
+		target string
+		status int
+	}{
+		{"viewer", http.MethodGet, "/api/v1/customers/1", http.StatusOK},
+		{"viewer", http.MethodGet, "/customers/add", http.StatusForbidden},
+		{"viewer", http.MethodPost, "/customers/1/edit", http.StatusForbidden},
+		{"editor", http.MethodGet, "/customers/add", http.StatusOK},
+		{"editor", http.MethodPost, "/customers/1/delete", http.StatusForbidden},
+		{"admin", http.MethodPost, "/customers/1/delete", http.StatusOK},
+		{"editor", http.MethodDelete, "/api/v1/customers/1", http.StatusForbidden},
+	}
+	for _, tc := range tt {
+		req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(url.Values{CsrfFieldName: {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(CsrfHeaderName, token)
+		req.AddCookie(csrfCookie)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tc.user + "-token"})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s %s by %s: expected %d, got %d", tc.method, tc.target, tc.user, tc.status, rec.Code)
+		}
+	}
+}
+
+func TestNavFollowsRole(t *testing.T) {
+	for _, tc := range []struct {
+		nav       navData
+		canEdit   bool
+		canDelete bool
+	}{
+		{navData{User: &models.User{Login: "viewer", Role: models.RoleViewer}}, false, false},
+		{navData{User: &models.User{Login: "editor", Role: models.RoleEditor}, CanEdit: true}, true, false},
+		{navData{User: &models.User{Login: "admin", Role: models.RoleAdmin}, CanEdit: true, CanDelete: true}, true, true},
+	} {
+		page := renderTemplate(t, "customers_list", &queryListData{
+			Customers: []dto.ListCustomerResultItem{{Id: 1}},
+			Nav:       tc.nav,
+		})
+		if strings.Contains(page, `href="/customers/add"`) != tc.canEdit || strings.Contains(page, `/customers/1/edit`) != tc.canEdit {
+			t.Errorf("%s: edit actions must be shown only to editors", tc.nav.User.Login)
+		}
+		if strings.Contains(page, `/customers/1/delete`) != tc.canDelete {
+			t.Errorf("%s: delete action must be shown only to admins", tc.nav.User.Login)
+		}
+		if !strings.Contains(page, `action="/logout"`) {
+			t.Errorf("%s: logout must be shown", tc.nav.User.Login)
+		}
+		history := renderTemplate(t, "customer_history", &CustomerHistoryPageData{Id: 1, Nav: tc.nav})
+		if strings.Contains(history, `/customers/1/edit`) != tc.canEdit {
+			t.Errorf("%s: history must link edit page only for editors", tc.nav.User.Login)
+		}
+	}
+}
+
+// api keys work without session and csrf token, but only with their scopes and only for api
+func TestApiKeys(t *testing.T) {
+	h := newAuthHandler()
+	tt := []struct {
+		key    string
+		method string
+		target string
+		status int
+	}{
+		{"cak_customers:read", http.MethodGet, "/api/v1/customers/1", http.StatusOK},
+		{"cak_customers:read", http.MethodDelete, "/api/v1/customers/1", http.StatusForbidden},
+		{"cak_customers:write", http.MethodGet, "/api/v1/customers/1", http.StatusForbidden},
+		{"cak_customers:delete", http.MethodDelete, "/api/v1/customers/1", http.StatusOK},
+		{"cak_unknown", http.MethodGet, "/api/v1/customers", http.StatusUnauthorized},
+		{"cak_customers:read", http.MethodGet, "/customers/deleted", http.StatusUnauthorized},
+	}
+	for _, tc := range tt {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s %s with %s: expected %d, got %d", tc.method, tc.target, tc.key, tc.status, rec.Code)
+		}
+		if rec.Code == http.StatusUnauthorized && tc.target != "/customers/deleted" && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Error("api must ask for bearer token")
+		}
+	}
+}
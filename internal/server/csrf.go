@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+)
+
+// Csrf protection works with double submit approach. Every client receives random secret in http only cookie,
+// and every rendered form receives masked copy of that secret in hidden field. State changing request is accepted
+// only if submitted token unmasks to the cookie secret. Cross site form can't read the cookie, so it can't build the token.
+// Token is masked with fresh one time pad on every render, so it doesn't leak through compressed responses(BREACH).
+const (
+	csrfCookieName = "csrf_secret"
+	// hidden form field name, templates must use the same name
+	CsrfFieldName = "csrf_token"
+	// json api sends token in header instead of form field
+	CsrfHeaderName = "X-CSRF-Token"
+	csrfSecretSize = 32
+)
+
+type csrfContextKey struct{}
+
+// wrap handler with csrf check of every unsafe request
+func csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		secret := readCsrfSecret(r)
+		if secret == nil {
+			secret = make([]byte, csrfSecretSize)
+			if _, err := rand.Read(secret); err != nil {
+				respFactory.Error(rw, err)
+				return
+			}
+			http.SetCookie(rw, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    base64.RawURLEncoding.EncodeToString(secret),
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, secret))
+		isApi := strings.HasPrefix(r.URL.Path, "/api/")
+		if isApi {
+			// api clients receive token in response header and send it back with unsafe requests
+			rw.Header().Set(CsrfHeaderName, csrfToken(r))
+		}
+		// browser doesn't add Authorization header to cross site requests, so requests with api key can't be forged
+		if isSafeMethod(r.Method) || isApi && bearerToken(r) != "" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		var token string
+		if isApi {
+			token = r.Header.Get(CsrfHeaderName)
+		} else {
+			token = r.PostFormValue(CsrfFieldName)
+		}
+		if !validCsrfToken(secret, token) {
+			if isApi {
+				apiRespFactory.CodeMessage(rw, codes.BadRequest, codes.KnownMessageInvalidCsrfToken)
+			} else {
+				respFactory.CodeMessage(rw, codes.BadRequest, codes.KnownMessageInvalidCsrfToken)
+			}
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// masked csrf token for current request, it must be rendered into every form
+func csrfToken(r *http.Request) string {
+	secret, ok := r.Context().Value(csrfContextKey{}).([]byte)
+	if !ok {
+		return ""
+	}
+	pad := make([]byte, len(secret))
+	if _, err := rand.Read(pad); err != nil {
+		return ""
+	}
+	masked := make([]byte, len(secret))
+	for i := range secret {
+		masked[i] = secret[i] ^ pad[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(append(pad, masked...))
+}
+
+func validCsrfToken(secret []byte, token string) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != 2*len(secret) {
+		return false
+	}
+	pad, masked := raw[:len(secret)], raw[len(secret):]
+	unmasked := make([]byte, len(secret))
+	for i := range secret {
+		unmasked[i] = pad[i] ^ masked[i]
+	}
+	return subtle.ConstantTimeCompare(unmasked, secret) == 1
+}
+
+func readCsrfSecret(r *http.Request) []byte {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil {
+		return nil
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(secret) != csrfSecretSize {
+		return nil
+	}
+	return secret
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
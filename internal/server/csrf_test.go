@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var okHandler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+	rw.WriteHeader(http.StatusOK)
+})
+
+// issue token with safe request, the same way as browser does when form is rendered
+func issueCsrf(t *testing.T, h http.Handler, target string) (*http.Cookie, string) {
+	var token string
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatal("csrf cookie wasn't set")
+	}
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.AddCookie(cookies[0])
+	csrfProtect(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		token = csrfToken(r)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	return cookies[0], token
+}
+
+func TestCsrfForm(t *testing.T) {
+	h := csrfProtect(okHandler)
+	cookie, token := issueCsrf(t, h, "/")
+	_, foreignToken := issueCsrf(t, h, "/")
+	type test struct {
+		name   string
+		token  string
+		cookie *http.Cookie
+		want   int
+	}
+	tt := []test{
+		{"valid token", token, cookie, http.StatusOK},
+		{"missing token", "", cookie, http.StatusBadRequest},
+		{"token of another client", foreignToken, cookie, http.StatusBadRequest},
+		{"missing cookie", token, nil, http.StatusBadRequest},
+		{"garbage token", "abc", cookie, http.StatusBadRequest},
+	}
+	for _, tc := range tt {
+		form := url.Values{CsrfFieldName: {tc.token}}
+		req := httptest.NewRequest(http.MethodPost, "/customers/add", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tc.cookie != nil {
+			req.AddCookie(tc.cookie)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+		}
+	}
+}
+
+func TestCsrfApiHeader(t *testing.T) {
+	h := csrfProtect(okHandler)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/customers", nil))
+	token := rec.Header().Get(CsrfHeaderName)
+	cookie := rec.Result().Cookies()[0]
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/customers/1", nil)
+	req.AddCookie(cookie)
+	req.Header.Set(CsrfHeaderName, token)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/customers/1", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Header().Get("Content-Type"), "json") {
+		t.Errorf("expected json bad request, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	"github.com/abdybaevae/customers-app/pkg/xlsx"
+)
+
+// columns of csv and xlsx export, the same names are used as json keys
+var exportColumns = []string{"id", "email", "firstName", "lastName", "birthDate", "gender", "address"}
+
+func exportValues(item *dto.ListCustomerResultItem) []string {
+	return []string{strconv.Itoa(item.Id), item.Email, item.FirstName, item.LastName, item.BirthDate, item.Gender, item.Address}
+}
+
+// writes exported customers in some format, Close finishes file
+type exportWriter interface {
+	Write(item *dto.ListCustomerResultItem) error
+	Close() error
+}
+
+type exportFormat struct {
+	contentType string
+	extension   string
+	newWriter   func(w io.Writer) (exportWriter, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":   {"text/csv; charset=utf-8", "csv", newCsvExportWriter},
+	"jsonl": {"application/x-ndjson", "jsonl", newJsonlExportWriter},
+	"xlsx":  {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx", newXlsxExportWriter},
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func newCsvExportWriter(w io.Writer) (exportWriter, error) {
+	cw := csv.NewWriter(w)
+	return &csvExportWriter{cw}, cw.Write(exportColumns)
+}
+
+func (e *csvExportWriter) Write(item *dto.ListCustomerResultItem) error {
+	values := exportValues(item)
+	for i, value := range values {
+		values[i] = escapeCsvFormula(value)
+	}
+	return e.w.Write(values)
+}
+
+func (e *csvExportWriter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// Spreadsheet programs run cell starting with these characters as formula, user typed values must stay text.
+func escapeCsvFormula(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@\t\r") {
+		return "'" + value
+	}
+	return value
+}
+
+type jsonlExportWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJsonlExportWriter(w io.Writer) (exportWriter, error) {
+	bw := bufio.NewWriter(w)
+	return &jsonlExportWriter{bw, json.NewEncoder(bw)}, nil
+}
+
+func (e *jsonlExportWriter) Write(item *dto.ListCustomerResultItem) error {
+	// encoder ends every value with new line
+	return e.enc.Encode(item)
+}
+
+func (e *jsonlExportWriter) Close() error {
+	return e.w.Flush()
+}
+
+type xlsxExportWriter struct {
+	w *xlsx.Writer
+}
+
+func newXlsxExportWriter(w io.Writer) (exportWriter, error) {
+	xw, err := xlsx.NewWriter(w, "Customers")
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxExportWriter{xw}, xw.WriteRow(exportColumns)
+}
+
+func (e *xlsxExportWriter) Write(item *dto.ListCustomerResultItem) error {
+	return e.w.WriteRow(exportValues(item))
+}
+
+func (e *xlsxExportWriter) Close() error {
+	return e.w.Close()
+}
+
+// Export all customers of list with the same search and order as list page. Customers are streamed to response
+// as they are read from database, so response starts only when the first customer is read(or export ends) and
+// error can't be reported to user after that, response is just broken.
+func (h *handler) exportCustomers(rw http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	formatName := query.Get("format")
+	if formatName == "" {
+		formatName = "csv"
+	}
+	format, ok := exportFormats[formatName]
+	if !ok {
+		respFactory.CodeMessage(rw, codes.BadRequest, codes.KnownMessageBadRequest)
+		return
+	}
+	args := &dto.ExportCustomersArguments{
+		SearchValue:  query.Get("searchValue"),
+		OrderBy:      query.Get("orderBy"),
+		OrderByValue: query.Get("orderByValue"),
+	}
+	if args.OrderBy == "" {
+		args.OrderBy = "customer_first_name"
+	}
+	if args.OrderByValue == "" {
+		args.OrderByValue = "asc"
+	}
+	var writer exportWriter
+	start := func() error {
+		rw.Header().Set("Content-Type", format.contentType)
+		rw.Header().Set("Content-Disposition", `attachment; filename="customers.`+format.extension+`"`)
+		var err error
+		writer, err = format.newWriter(rw)
+		return err
+	}
+	err := h.customerService.Export(r.Context(), args, func(item *dto.ListCustomerResultItem) error {
+		if writer == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return writer.Write(item)
+	})
+	if err != nil && writer == nil {
+		respFactory.Error(rw, err)
+		return
+	}
+	if err == nil && writer == nil {
+		// nothing matched, file has header only
+		err = start()
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		reqinfo.Logger(r.Context(), h.log).Errorf("customers export was interrupted: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	"github.com/sirupsen/logrus"
+)
+
+func exportRequest(service *fakeService, target string) *httptest.ResponseRecorder {
+	h := &handler{customerService: service, log: logrus.NewEntry(logrus.New())}
+	rec := httptest.NewRecorder()
+	h.exportCustomers(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestExportFormats(t *testing.T) {
+	service := newFakeService()
+	service.Create(context.Background(), &dto.CreateCustomerArguments{CustomerItem: dto.CustomerItem{Email: "john@doe.com", FirstName: "John", Address: "=HYPERLINK(\"x\")"}})
+	service.Create(context.Background(), &dto.CreateCustomerArguments{CustomerItem: dto.CustomerItem{Email: "jane@doe.com", FirstName: "Jane"}})
+
+	rec := exportRequest(service, "/customers/export?format=csv")
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 || lines[0] != strings.Join(exportColumns, ",") || !strings.Contains(lines[1], `"'=HYPERLINK(""x"")"`) {
+		t.Error("unexpected csv", rec.Body.String())
+	}
+	if rec.Header().Get("Content-Disposition") != `attachment; filename="customers.csv"` {
+		t.Error("csv must be downloaded as file", rec.Header())
+	}
+
+	rec = exportRequest(service, "/customers/export?format=jsonl")
+	lines = strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], `{"id":2,"email":"jane@doe.com"`) {
+		t.Error("unexpected json lines", rec.Body.String())
+	}
+
+	rec = exportRequest(service, "/customers/export?format=xlsx")
+	if !strings.HasPrefix(rec.Body.String(), "PK") {
+		t.Error("xlsx must be zip archive")
+	}
+
+	rec = exportRequest(service, "/customers/export?format=pdf")
+	if rec.Code != http.StatusBadRequest {
+		t.Error("unknown format must be rejected", rec.Code)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// responses smaller than this are sent as is, compression doesn't pay off for them
+const gzipMinSize = 1024
+
+var gzipWriters = sync.Pool{New: func() interface{} {
+	w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+	return w
+}}
+
+// content types which are already compressed(xlsx export is zip archive)
+var compressedTypes = []string{"image/", "application/zip", "application/gzip", "application/vnd.openxmlformats"}
+
+// Compress responses for clients accepting gzip. Decision is made on the first write, when content type is known.
+func gzipResponses(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.Method == http.MethodHead || !acceptsGzip(r) {
+		next(rw, r)
+		return
+	}
+	rw.Header().Add("Vary", "Accept-Encoding")
+	grw := &gzipResponseWriter{ResponseWriter: rw}
+	// not deferred: after panic buffered part of response is dropped and recovery renders error instead
+	next(grw, r)
+	grw.close()
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	decided bool
+	gz      *gzip.Writer
+	// first small writes are kept till it's clear if response is big enough
+	buf []byte
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) < gzipMinSize {
+		return len(b), nil
+	}
+	if err := w.decide(true); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// start response compressed or plain and write buffered content
+func (w *gzipResponseWriter) decide(bigEnough bool) error {
+	w.decided = true
+	header := w.ResponseWriter.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) != 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if bigEnough && w.compressible(status) {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+		w.ResponseWriter.WriteHeader(status)
+		_, err := w.gz.Write(w.buf)
+		w.buf = nil
+		return err
+	}
+	w.ResponseWriter.WriteHeader(status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *gzipResponseWriter) compressible(status int) bool {
+	header := w.ResponseWriter.Header()
+	// partial content of file server can't be compressed, ranges refer to plain content
+	if status == http.StatusPartialContent || status == http.StatusNoContent || status == http.StatusNotModified ||
+		header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	for _, compressed := range compressedTypes {
+		if strings.HasPrefix(contentType, compressed) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush sends buffered content, streamed export reaches client without waiting for the end
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf) > 0)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
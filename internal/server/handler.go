@@ -1,17 +1,24 @@
 package server
 
 import (
+	"context"
+	"html/template"
 	"net/http"
 	"strconv"
-	"text/template"
 	"time"
 
 	"github.com/abdybaevae/customers-app/conf"
 	"github.com/abdybaevae/customers-app/pkg/codes"
 	"github.com/abdybaevae/customers-app/pkg/custval"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/ratelimit"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
 	"github.com/abdybaevae/customers-app/pkg/resp"
+	apikeyservice "github.com/abdybaevae/customers-app/pkg/services/apikey"
 	customerservice "github.com/abdybaevae/customers-app/pkg/services/customer"
 	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	idempotencyservice "github.com/abdybaevae/customers-app/pkg/services/idempotency"
+	userservice "github.com/abdybaevae/customers-app/pkg/services/user"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
@@ -20,9 +27,19 @@ const birthDateLayout = "2006-01-02"
 
 type handler struct {
 	customerService customerservice.CustomerService
+	userService     userservice.UserService
+	apiKeyService   apikeyservice.ApiKeyService
 	templates       *template.Template
 	log             *logrus.Entry
 	Cfg             *conf.Config
+	ready           func(ctx context.Context) error
+	rateLimits      ratelimit.Store
+	idempotency     idempotencyservice.IdempotencyService
+}
+
+// forms are limited by configured customer rules(birthdate pickers, genders and lengths)
+func (h *handler) rules() custval.Rules {
+	return h.Cfg.Customers.Rules()
 }
 
 type pages int
@@ -41,29 +58,22 @@ type queryListData struct {
 	Customers   []dto.ListCustomerResultItem
 	Next        bool
 	Prev        bool
-	NextValue   int
-	PrevValue   int
+	NextCursor  string
+	PrevCursor  string
 	SearchValue string
+	// current order, export uses it too
+	OrderBy      string
+	OrderByValue string
+	CsrfToken    string
+	Nav          navData
 }
 
 func (h *handler) showListPage(rw http.ResponseWriter, r *http.Request) {
 	args := &dto.ListCustomersArguments{
 		OrderBy:      "customer_first_name",
-		Page:         0,
 		OrderByValue: "asc",
 	}
-	data, err := h.customerService.QueryList(r.Context(), args)
-	if err != nil {
-		respFactory.Error(rw, err)
-		return
-	}
-	tempData := &queryListData{
-		Customers: data.Customers,
-		Next:      len(data.Customers) == customerservice.CustomersPerPage,
-		Prev:      false,
-		NextValue: args.Page + 1,
-	}
-	h.templates.ExecuteTemplate(rw, "customers_list", tempData)
+	h.renderList(rw, r, args)
 }
 func (h *handler) queryList(rw http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
@@ -74,41 +84,50 @@ func (h *handler) queryList(rw http.ResponseWriter, r *http.Request) {
 		OrderBy:      r.FormValue("orderBy"),
 		SearchValue:  r.FormValue("searchValue"),
 		OrderByValue: r.FormValue("orderByValue"),
+		Cursor:       r.FormValue("cursor"),
 	}
 	if queryArgs.OrderByValue == "" {
 		queryArgs.OrderByValue = "asc"
 	}
-	page, err := strconv.Atoi(r.FormValue("page"))
-	if err != nil {
-		page = 0
-	}
-	queryArgs.Page = page
-	data, err := h.customerService.QueryList(r.Context(), queryArgs)
+	h.renderList(rw, r, queryArgs)
+}
+func (h *handler) renderList(rw http.ResponseWriter, r *http.Request, args *dto.ListCustomersArguments) {
+	data, err := h.customerService.QueryList(r.Context(), args)
 	if err != nil {
 		respFactory.Error(rw, err)
 		return
 	}
 	tempData := &queryListData{
-		Customers:   data.Customers,
-		Next:        len(data.Customers) == customerservice.CustomersPerPage,
-		Prev:        queryArgs.Page != 0,
-		NextValue:   queryArgs.Page + 1,
-		PrevValue:   queryArgs.Page - 1,
-		SearchValue: queryArgs.SearchValue,
+		Customers:    data.Customers,
+		Next:         data.HasNext,
+		Prev:         data.HasPrev,
+		NextCursor:   data.NextCursor,
+		PrevCursor:   data.PrevCursor,
+		SearchValue:  args.SearchValue,
+		OrderBy:      args.OrderBy,
+		OrderByValue: args.OrderByValue,
+		CsrfToken:    csrfToken(r),
+		Nav:          h.nav(r),
 	}
 	h.templates.ExecuteTemplate(rw, "customers_list", tempData)
 }
 
 type AddCustomerPageData struct {
-	MinDate string
-	MaxDate string
+	MinDate   string
+	MaxDate   string
+	CsrfToken string
+	Rules     custval.Rules
+	Nav       navData
 }
 
 func (h *handler) addCustomerPage(rw http.ResponseWriter, r *http.Request) {
-	min, max := custval.ComputeBirthDateRange()
+	min, max := h.rules().Ages.BirthDateRange()
 	data := &AddCustomerPageData{
-		MinDate: min.Format(birthDateLayout),
-		MaxDate: max.Format(birthDateLayout),
+		MinDate:   min.Format(birthDateLayout),
+		MaxDate:   max.Format(birthDateLayout),
+		CsrfToken: csrfToken(r),
+		Rules:     h.rules(),
+		Nav:       h.nav(r),
 	}
 	h.templates.ExecuteTemplate(rw, "create_customer", data)
 }
@@ -119,7 +138,7 @@ func (h *handler) handleAddCustomer(rw http.ResponseWriter, r *http.Request) {
 	}
 	birthDate, err := time.Parse(birthDateLayout, r.PostForm.Get("birthDate"))
 	if err != nil {
-		h.log.Error(err)
+		reqinfo.Logger(r.Context(), h.log).Error(err)
 		respFactory.CodeMessage(rw, codes.InvalidData, codes.KnownMessageCustomerInvalidBirthDate)
 		return
 	}
@@ -133,7 +152,7 @@ func (h *handler) handleAddCustomer(rw http.ResponseWriter, r *http.Request) {
 			Email:     r.PostForm.Get("email"),
 		},
 	}
-	err = h.customerService.Create(r.Context(), addArgs)
+	_, err = h.customerService.Create(r.Context(), addArgs)
 	if err == codes.UniqueConstraintViolation {
 		respFactory.CodeMessage(rw, codes.BadRequest, codes.KnownMessageGivenEmailBusyUseAnotherOne)
 		return
@@ -151,10 +170,51 @@ type EditCustomerPageData struct {
 	LastName  string
 	BirthDate string
 	Gender    string
+	Email     string
 	Address   string
 	Hash      string
 	MaxDate   string
 	MinDate   string
+	CsrfToken string
+	Rules     custval.Rules
+	// customer values that user started editing from, they are sent back with changes
+	Original customerFormValues
+	// filled if customer was edited concurrently
+	Conflict []conflictField
+	Nav      navData
+}
+
+// editable customer fields as they are shown in form
+type customerFormValues struct {
+	FirstName string
+	LastName  string
+	BirthDate string
+	Gender    string
+	Email     string
+	Address   string
+}
+
+// three way comparison of field: user's value, current server value and value user started from
+type conflictField struct {
+	Field    string
+	Yours    string
+	Server   string
+	Original string
+	// server value was changed by another user
+	ServerChanged bool
+	// both users changed field to different values
+	Conflicting bool
+}
+
+func formValuesOf(customer *models.Customer) customerFormValues {
+	return customerFormValues{
+		FirstName: customer.FirstName,
+		LastName:  customer.LastName,
+		BirthDate: customer.BirthDate.Format(birthDateLayout),
+		Gender:    customer.Gender,
+		Email:     customer.Email,
+		Address:   customer.Address,
+	}
 }
 
 func (h *handler) editCustomerPage(rw http.ResponseWriter, r *http.Request) {
@@ -169,20 +229,31 @@ func (h *handler) editCustomerPage(rw http.ResponseWriter, r *http.Request) {
 		respFactory.Error(rw, err)
 		return
 	}
-
-	min, max := custval.ComputeBirthDateRange()
+	values := formValuesOf(customer)
+	h.renderEditPage(rw, r, customer.Id, customer.Hash, values, values, nil)
+}
+func (h *handler) renderEditPage(rw http.ResponseWriter, r *http.Request, id int, hash string, values, original customerFormValues, conflict []conflictField) {
+	min, max := h.rules().Ages.BirthDateRange()
 	data := EditCustomerPageData{
-		Id:        customer.Id,
-		FirstName: customer.FirstName,
-		LastName:  customer.LastName,
-		BirthDate: customer.BirthDate.Format(birthDateLayout),
-		Gender:    customer.Gender,
-		Address:   customer.Address,
-		Hash:      customer.Hash,
+		Id:        id,
+		FirstName: values.FirstName,
+		LastName:  values.LastName,
+		BirthDate: values.BirthDate,
+		Gender:    values.Gender,
+		Email:     values.Email,
+		Address:   values.Address,
+		Hash:      hash,
 		MinDate:   min.Format(birthDateLayout),
 		MaxDate:   max.Format(birthDateLayout),
+		CsrfToken: csrfToken(r),
+		Rules:     h.rules(),
+		Original:  original,
+		Conflict:  conflict,
+		Nav:       h.nav(r),
+	}
+	if conflict != nil {
+		rw.WriteHeader(codes.StatusCode(codes.OverwriteData))
 	}
-
 	h.templates.ExecuteTemplate(rw, "edit_customer", data)
 }
 func (h *handler) handleUpdateCustomer(rw http.ResponseWriter, r *http.Request) {
@@ -207,15 +278,79 @@ func (h *handler) handleUpdateCustomer(rw http.ResponseWriter, r *http.Request)
 		LastName:  r.PostForm.Get("lastName"),
 		Gender:    r.PostForm.Get("gender"),
 		BirthDate: birthDate,
+		Email:     r.PostForm.Get("email"),
 		Address:   r.PostForm.Get("address"),
 		Hash:      r.PostForm.Get("hash"),
 	}
+	// without original values concurrent changes can't be merged, whole update is rejected in this case
+	if originalBirthDate, err := time.Parse(birthDateLayout, r.PostForm.Get("originalBirthDate")); err == nil {
+		editArgs.Original = &dto.CustomerFields{
+			FirstName: r.PostForm.Get("originalFirstName"),
+			LastName:  r.PostForm.Get("originalLastName"),
+			BirthDate: originalBirthDate,
+			Gender:    r.PostForm.Get("originalGender"),
+			Email:     r.PostForm.Get("originalEmail"),
+			Address:   r.PostForm.Get("originalAddress"),
+		}
+	}
 	if err := h.customerService.Update(r.Context(), editArgs); err != nil {
+		if dataErr, ok := err.(codes.DataErrorCode); ok {
+			if conflict, ok := dataErr.Data().(*dto.UpdateConflict); ok {
+				h.renderConflict(rw, r, conflict)
+				return
+			}
+		}
 		respFactory.Error(rw, err)
 		return
 	}
 	respFactory.CodeMessage(rw, codes.Ok, codes.KnownMessageCustomerEdited)
 }
+
+// Customer was edited by someone else and changes can't be merged. Form is shown again with merged values
+// (user's value for fields changed by user, current value for others), current values become new original
+// and user can decide what to keep in conflicting fields.
+func (h *handler) renderConflict(rw http.ResponseWriter, r *http.Request, conflict *dto.UpdateConflict) {
+	isConflicting := map[string]bool{}
+	for _, field := range conflict.Fields {
+		isConflicting[field] = true
+	}
+	server := formValuesOf(conflict.Current)
+	merged := customerFormValues{}
+	// service field name is the same as form field name
+	fields := []struct {
+		name         string
+		originalName string
+		label        string
+		server       string
+		merged       *string
+	}{
+		{customerservice.FieldFirstName, "originalFirstName", "Firstname", server.FirstName, &merged.FirstName},
+		{customerservice.FieldLastName, "originalLastName", "Lastname", server.LastName, &merged.LastName},
+		{customerservice.FieldBirthDate, "originalBirthDate", "Birthdate", server.BirthDate, &merged.BirthDate},
+		{customerservice.FieldGender, "originalGender", "Gender", server.Gender, &merged.Gender},
+		{customerservice.FieldEmail, "originalEmail", "E-mail address", server.Email, &merged.Email},
+		{customerservice.FieldAddress, "originalAddress", "Address", server.Address, &merged.Address},
+	}
+	rows := []conflictField{}
+	for _, field := range fields {
+		yours := r.PostForm.Get(field.name)
+		original := r.PostForm.Get(field.originalName)
+		*field.merged = field.server
+		if yours != original {
+			*field.merged = yours
+		}
+		rows = append(rows, conflictField{
+			Field:         field.label,
+			Yours:         yours,
+			Server:        field.server,
+			Original:      original,
+			ServerChanged: field.server != original,
+			Conflicting:   isConflicting[field.name] || len(conflict.Fields) == 0 && yours != field.server,
+		})
+	}
+	h.renderEditPage(rw, r, conflict.Current.Id, conflict.Current.Hash, merged, server, rows)
+}
+
 func (h *handler) handleDeleteCustomer(rw http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	customerId, err := strconv.Atoi(vars["customerId"])
@@ -229,3 +364,117 @@ func (h *handler) handleDeleteCustomer(rw http.ResponseWriter, r *http.Request)
 	}
 	respFactory.CodeMessage(rw, codes.Ok, codes.KnownMessageCustomerDeleted)
 }
+
+// human readable names of audited customer fields
+var auditFieldLabels = map[string]string{
+	"customer_first_name": "Firstname",
+	"customer_last_name":  "Lastname",
+	"customer_birth_date": "Birthdate",
+	"customer_gender":     "Gender",
+	"customer_email":      "E-mail address",
+	"customer_address":    "Address",
+}
+
+type historyChange struct {
+	Field  string
+	Before string
+	After  string
+}
+type historyEntry struct {
+	Action    string
+	Actor     string
+	RequestId string
+	CreatedAt string
+	Changes   []historyChange
+}
+type CustomerHistoryPageData struct {
+	Id      int
+	Entries []historyEntry
+	Nav     navData
+}
+
+func (h *handler) customerHistoryPage(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	customerId, err := strconv.Atoi(vars["customerId"])
+	if err != nil {
+		respFactory.CodeMessage(rw, codes.NotFound, codes.KnownMessageNotFoundPage)
+		return
+	}
+	history, err := h.customerService.History(r.Context(), customerId)
+	if err != nil {
+		respFactory.Error(rw, err)
+		return
+	}
+	data := &CustomerHistoryPageData{Id: customerId, Nav: h.nav(r)}
+	for _, audit := range history {
+		entry := historyEntry{
+			Action:    audit.Action,
+			Actor:     audit.Actor,
+			RequestId: audit.RequestId,
+			CreatedAt: audit.CreatedAt.Format("2006-01-02 15:04:05"),
+		}
+		for _, change := range audit.Changes {
+			label, ok := auditFieldLabels[change.Field]
+			if !ok {
+				label = change.Field
+			}
+			item := historyChange{Field: label}
+			if change.Before != nil {
+				item.Before = *change.Before
+			}
+			if change.After != nil {
+				item.After = *change.After
+			}
+			entry.Changes = append(entry.Changes, item)
+		}
+		data.Entries = append(data.Entries, entry)
+	}
+	h.templates.ExecuteTemplate(rw, "customer_history", data)
+}
+
+type deletedListData struct {
+	Customers  []dto.ListCustomerResultItem
+	Next       bool
+	Prev       bool
+	NextCursor string
+	PrevCursor string
+	CsrfToken  string
+	Nav        navData
+}
+
+func (h *handler) deletedListPage(rw http.ResponseWriter, r *http.Request) {
+	args := &dto.ListCustomersArguments{
+		OrderBy:      "customer_first_name",
+		OrderByValue: "asc",
+		Cursor:       r.URL.Query().Get("cursor"),
+		Deleted:      true,
+	}
+	data, err := h.customerService.QueryList(r.Context(), args)
+	if err != nil {
+		respFactory.Error(rw, err)
+		return
+	}
+	tempData := &deletedListData{
+		Customers:  data.Customers,
+		Next:       data.HasNext,
+		Prev:       data.HasPrev,
+		NextCursor: data.NextCursor,
+		PrevCursor: data.PrevCursor,
+		CsrfToken:  csrfToken(r),
+		Nav:        h.nav(r),
+	}
+	h.templates.ExecuteTemplate(rw, "deleted_customers", tempData)
+}
+func (h *handler) handleRestoreCustomer(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	customerId, err := strconv.Atoi(vars["customerId"])
+	if err != nil {
+		respFactory.CodeMessage(rw, codes.NotFound, codes.KnownCustomerNotFound)
+		return
+	}
+	if err := h.customerService.Restore(r.Context(), customerId); err != nil {
+		respFactory.Error(rw, err)
+		return
+	}
+	respFactory.CodeMessage(rw, codes.Ok, codes.KnownMessageCustomerRestored)
+}
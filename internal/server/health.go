@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/metrics"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
+)
+
+// readiness check must answer before probe of orchestrator gives up
+const readyTimeout = 3 * time.Second
+
+// Liveness: process is up and serves requests, dependencies aren't checked
+func (h *handler) healthz(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.Write([]byte("ok\n"))
+}
+
+// Readiness: server can serve customers(database answers and has expected schema)
+func (h *handler) readyz(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if h.ready != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := h.ready(ctx); err != nil {
+			reqinfo.Logger(r.Context(), h.log).Warnf("server isn't ready: %v", err)
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			rw.Write([]byte("not ready\n"))
+			return
+		}
+	}
+	rw.Write([]byte("ok\n"))
+}
+
+// metrics of server and customer changes, scraper authenticates with api key of metrics:read scope
+const metricsPath = "/metrics"
+
+// Request count and latency of every route, route is path template so customer ids don't create new series.
+// It wraps whole router, so requests rejected before routing and requests without route(404, 405) are counted too,
+// the latter with "unmatched" route.
+func measureRequests(next http.Handler, router *mux.Router, registry *metrics.Registry) http.Handler {
+	requests := registry.Counter("http_requests_total", "Count of handled http requests.", "method", "route", "code")
+	latency := registry.Histogram("http_request_duration_seconds", "Latency of http requests.", metrics.DefaultBuckets, "method", "route")
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.MatchErr == nil && match.Route != nil {
+			if template, err := match.Route.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		start := time.Now()
+		nrw := negroni.NewResponseWriter(rw)
+		next.ServeHTTP(nrw, r)
+		status := nrw.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		requests.Inc(r.Method, route, strconv.Itoa(status))
+		latency.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/abdybaevae/customers-app/conf"
+	"github.com/abdybaevae/customers-app/pkg/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	var readyErr error
+	registry := metrics.NewRegistry()
+	handler := NewHandler(newFakeService(), newFakeUserService(), newFakeApiKeyService(), conf.Default(), logrus.NewEntry(logrus.New()), Options{
+		Ready:   func(ctx context.Context) error { return readyErr },
+		Metrics: registry,
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Error("server must be alive", rec.Code)
+	}
+	if rec := get("/readyz"); rec.Code != http.StatusOK {
+		t.Error("server must be ready", rec.Code)
+	}
+	readyErr = errors.New("connection refused")
+	if rec := get("/readyz"); rec.Code != http.StatusServiceUnavailable || strings.Contains(rec.Body.String(), "refused") {
+		t.Error("server must not be ready and must not expose error", rec.Code, rec.Body.String())
+	}
+	get("/api/v1/customers/42")
+	get("/no/such/page")
+	put := httptest.NewRequest(http.MethodPut, "/api/v1/customers", nil)
+	put.Header.Set("Authorization", "Bearer cak_customers:write")
+	handler.ServeHTTP(httptest.NewRecorder(), put)
+	if rec := get("/metrics"); rec.Code != http.StatusUnauthorized {
+		t.Error("metrics must not be public", rec.Code)
+	}
+	scrape := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	scrape.Header.Set("Authorization", "Bearer cak_metrics:read")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, scrape)
+	body := rec.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/readyz",code="503"} 1`,
+		`http_requests_total{method="GET",route="/api/v1/customers/{customerId}",code="401"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/healthz"} 1`,
+		`http_requests_total{method="GET",route="unmatched",code="404"} 1`,
+		`http_requests_total{method="PUT",route="unmatched",code="405"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics must contain %s", line)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+)
+
+// Client sends random key with create, update or delete request and repeats it when request is retried(e.g. after timeout).
+// The first request is handled and its response is stored, retry receives the stored response with replay header
+// instead of repeating the change. Key of client(actor) can't be used for another request.
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+var validIdempotencyKey = regexp.MustCompile(`^[\x21-\x7e]{1,255}$`)
+
+// headers which are stored and replayed with response body
+var idempotentHeaders = []string{"Content-Type", "Location"}
+
+func (h *handler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || h.idempotency == nil {
+			next(rw, r)
+			return
+		}
+		if !validIdempotencyKey.MatchString(key) {
+			h.idempotencyError(rw, r, codes.NewErr(codes.BadRequest, codes.KnownMessageInvalidIdempotencyKey))
+			return
+		}
+		fingerprint, err := requestFingerprint(r)
+		if err != nil {
+			h.idempotencyError(rw, r, codes.NewErr(codes.BadRequest, codes.KnownMessageBadRequest))
+			return
+		}
+		owner := reqinfo.Actor(r.Context())
+		stored, err := h.idempotency.Begin(r.Context(), owner, key, fingerprint)
+		if err != nil {
+			h.idempotencyError(rw, r, err)
+			return
+		}
+		if stored != nil {
+			replayResponse(rw, stored)
+			return
+		}
+		recorder := &recordingResponseWriter{ResponseWriter: rw}
+		// response must be stored even if client has gone(it's the case when client retries), so request context isn't used
+		failed := true
+		defer func() {
+			if !failed {
+				return
+			}
+			// failed request(or panic) can be retried with the same key
+			if err := h.idempotency.Release(context.Background(), owner, key); err != nil {
+				reqinfo.Logger(r.Context(), h.log).Errorf("idempotency key wasn't released: %v", err)
+			}
+		}()
+		next(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		failed = false
+		request := &models.IdempotentRequest{Owner: owner, Key: key, Status: &recorder.status, Headers: models.Headers{}, Body: recorder.body.Bytes()}
+		for _, name := range idempotentHeaders {
+			if value := rw.Header().Get(name); value != "" {
+				request.Headers[name] = value
+			}
+		}
+		h.completeIdempotent(r, request)
+	}
+}
+
+const (
+	completeAttempts   = 3
+	completeRetryDelay = 100 * time.Millisecond
+)
+
+// Response of handled request is stored. Key isn't released if response can't be stored, change is done already
+// and retry must not repeat it, so retries receive IdempotencyKeyInProgress till lock timeout.
+func (h *handler) completeIdempotent(r *http.Request, request *models.IdempotentRequest) {
+	for attempt := 1; ; attempt++ {
+		err := h.idempotency.Complete(context.Background(), request)
+		if err == nil {
+			return
+		}
+		if attempt == completeAttempts {
+			reqinfo.Logger(r.Context(), h.log).Errorf("response of idempotency key wasn't stored: %v", err)
+			return
+		}
+		time.Sleep(completeRetryDelay)
+	}
+}
+
+func (h *handler) idempotencyError(rw http.ResponseWriter, r *http.Request, err error) {
+	if isApiRequest(r) {
+		apiRespFactory.Error(rw, err)
+	} else {
+		respFactory.Error(rw, err)
+	}
+}
+
+// Hash of method, url and body. Form is parsed already by csrf check, it's hashed without csrf token,
+// because token is masked differently on every page render.
+func requestFingerprint(r *http.Request) (string, error) {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		if err := r.ParseForm(); err != nil {
+			return "", err
+		}
+		form := url.Values{}
+		for name, values := range r.PostForm {
+			if name != CsrfFieldName {
+				form[name] = values
+			}
+		}
+		io.WriteString(hash, form.Encode())
+	} else {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		hash.Write(body)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func replayResponse(rw http.ResponseWriter, stored *models.IdempotentRequest) {
+	for name, value := range stored.Headers {
+		rw.Header().Set(name, value)
+	}
+	rw.Header().Set(idempotentReplayedHeader, "true")
+	rw.WriteHeader(*stored.Status)
+	rw.Write(stored.Body)
+}
+
+// response writer which keeps copy of response
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
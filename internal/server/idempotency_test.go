@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/abdybaevae/customers-app/conf"
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	idempotencyservice "github.com/abdybaevae/customers-app/pkg/services/idempotency"
+	"github.com/sirupsen/logrus"
+)
+
+// in memory idempotency keys, request is in progress only if its response wasn't stored
+type fakeIdempotencyService struct {
+	idempotencyservice.IdempotencyService
+	requests    map[string]*models.IdempotentRequest
+	completeErr error
+}
+
+func (f *fakeIdempotencyService) Begin(ctx context.Context, owner, key, fingerprint string) (*models.IdempotentRequest, error) {
+	stored, ok := f.requests[owner+"/"+key]
+	if !ok {
+		f.requests[owner+"/"+key] = &models.IdempotentRequest{Owner: owner, Key: key, Fingerprint: fingerprint}
+		return nil, nil
+	}
+	if stored.Fingerprint != fingerprint {
+		return nil, codes.NewErr(codes.IdempotencyKeyReused, codes.KnownMessageIdempotencyKeyReused)
+	}
+	if !stored.IsCompleted() {
+		return nil, codes.NewErr(codes.IdempotencyKeyInProgress, codes.KnownMessageIdempotencyKeyInProgress)
+	}
+	return stored, nil
+}
+
+func (f *fakeIdempotencyService) Complete(ctx context.Context, request *models.IdempotentRequest) error {
+	if f.completeErr != nil {
+		return f.completeErr
+	}
+	stored := f.requests[request.Owner+"/"+request.Key]
+	stored.Status, stored.Headers, stored.Body = request.Status, request.Headers, request.Body
+	return nil
+}
+
+func (f *fakeIdempotencyService) Release(ctx context.Context, owner, key string) error {
+	delete(f.requests, owner+"/"+key)
+	return nil
+}
+
+func newIdempotentHandler(service *fakeService) http.Handler {
+	return newIdempotentHandlerWith(service, &fakeIdempotencyService{requests: map[string]*models.IdempotentRequest{}})
+}
+
+func newIdempotentHandlerWith(service *fakeService, idempotency *fakeIdempotencyService) http.Handler {
+	return NewHandler(service, newFakeUserService(), newFakeApiKeyService(), conf.Default(), logrus.NewEntry(logrus.New()), Options{Idempotency: idempotency})
+}
+
+func TestIdempotentApiCreate(t *testing.T) {
+	service := newFakeService()
+	h := newIdempotentHandler(service)
+	create := func(key, email string) *httptest.ResponseRecorder {
+		body := `{"firstName":"John","lastName":"Doe","birthDate":"1990-01-02","gender":"male","email":"` + email + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/customers", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer cak_customers:write")
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	first := create("retry-1", "john@doe.com")
+	if first.Code != http.StatusCreated || first.Header().Get(idempotentReplayedHeader) != "" {
+		t.Fatal("first request must be handled", first.Code, first.Body)
+	}
+	retry := create("retry-1", "john@doe.com")
+	if retry.Code != http.StatusCreated || retry.Header().Get(idempotentReplayedHeader) != "true" ||
+		retry.Header().Get("Location") != first.Header().Get("Location") || retry.Body.String() != first.Body.String() {
+		t.Error("retry must receive stored response", retry.Code, retry.Header(), retry.Body)
+	}
+	if len(service.customers) != 1 {
+		t.Error("retry must not create customer", len(service.customers))
+	}
+	if rec := create("retry-1", "jane@doe.com"); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), `"IdempotencyKeyReused"`) {
+		t.Error("key must not be reused for another body", rec.Code, rec.Body)
+	}
+	if rec := create("bad key", "jane@doe.com"); rec.Code != http.StatusBadRequest {
+		t.Error("invalid key must be rejected", rec.Code)
+	}
+	if rec := create("", "john@doe.com"); rec.Code != http.StatusBadRequest {
+		t.Error("request without key is handled as usual", rec.Code)
+	}
+}
+
+// form is sent again with csrf token of reloaded page, it's the same request
+func TestIdempotentFormDelete(t *testing.T) {
+	service := newFakeService()
+	service.customers[1] = &models.Customer{Id: 1, FirstName: "John"}
+	h := newIdempotentHandler(service)
+	csrfCookie, _ := issueCsrf(t, h, "/login")
+	remove := func() *httptest.ResponseRecorder {
+		var token string
+		page := httptest.NewRequest(http.MethodGet, "/login", nil)
+		page.AddCookie(csrfCookie)
+		csrfProtect(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			token = csrfToken(r)
+		})).ServeHTTP(httptest.NewRecorder(), page)
+		req := httptest.NewRequest(http.MethodPost, "/customers/1/delete", strings.NewReader(url.Values{CsrfFieldName: {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(idempotencyKeyHeader, "delete-1")
+		req.AddCookie(csrfCookie)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "admin-token"})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := remove(); rec.Code != http.StatusOK {
+		t.Fatal("customer wasn't deleted", rec.Code)
+	}
+	if rec := remove(); rec.Code != http.StatusOK || rec.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Error("retry must receive stored response instead of not found", rec.Code)
+	}
+}
+
+// change is done, so key stays reserved even if response can't be stored and retry doesn't repeat the change
+func TestIdempotentResponseNotStored(t *testing.T) {
+	service := newFakeService()
+	idempotency := &fakeIdempotencyService{requests: map[string]*models.IdempotentRequest{}, completeErr: errors.New("database is down")}
+	h := newIdempotentHandlerWith(service, idempotency)
+	create := func() *httptest.ResponseRecorder {
+		body := `{"firstName":"John","lastName":"Doe","birthDate":"1990-01-02","gender":"male","email":"john@doe.com"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/customers", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer cak_customers:write")
+		req.Header.Set(idempotencyKeyHeader, "retry-1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := create(); rec.Code != http.StatusCreated {
+		t.Fatal("first request must be handled", rec.Code, rec.Body)
+	}
+	if rec := create(); rec.Code != http.StatusConflict || len(service.customers) != 1 {
+		t.Error("retry must not repeat the change", rec.Code, len(service.customers))
+	}
+}
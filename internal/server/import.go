@@ -0,0 +1,113 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/custval"
+	"github.com/abdybaevae/customers-app/pkg/resp"
+	customerservice "github.com/abdybaevae/customers-app/pkg/services/customer"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+)
+
+// Import files are csv or tsv. Format is taken from explicit value first, then from file name extension or content type.
+func importComma(format, fileName, contentType string) (rune, bool) {
+	if format == "" {
+		switch {
+		case strings.EqualFold(filepath.Ext(fileName), ".tsv"), strings.HasPrefix(contentType, "text/tab-separated-values"):
+			format = "tsv"
+		default:
+			format = "csv"
+		}
+	}
+	switch format {
+	case "csv":
+		return ',', true
+	case "tsv":
+		return '\t', true
+	}
+	return 0, false
+}
+
+type ImportCustomersPageData struct {
+	CsrfToken   string
+	DryRun      bool
+	SkipInvalid bool
+	Rules       custval.Rules
+	// filled after file was uploaded
+	Result *dto.ImportCustomersResult
+	Nav    navData
+}
+
+func (h *handler) importCustomersPage(rw http.ResponseWriter, r *http.Request) {
+	h.templates.ExecuteTemplate(rw, "import_customers", &ImportCustomersPageData{CsrfToken: csrfToken(r), DryRun: true, Rules: h.rules(), Nav: h.nav(r)})
+}
+
+func (h *handler) handleImportCustomers(rw http.ResponseWriter, r *http.Request) {
+	maxImportSize := h.Cfg.Server.MaxImportSize
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		respFactory.CodeMessage(rw, codes.BadRequest, codes.KnownMessageBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil || header.Size > maxImportSize {
+		respFactory.CodeMessage(rw, codes.BadRequest, codes.KnownMessageBadRequest)
+		return
+	}
+	defer file.Close()
+	comma, ok := importComma(r.PostForm.Get("format"), header.Filename, header.Header.Get("Content-Type"))
+	if !ok {
+		respFactory.CodeMessage(rw, codes.InvalidData, codes.KnownMessageImportMalformedFile)
+		return
+	}
+	args := &dto.ImportCustomersArguments{
+		DryRun:      r.PostForm.Get("dryRun") != "",
+		SkipInvalid: r.PostForm.Get("skipInvalid") != "",
+	}
+	result, err := h.importCustomers(r, file, comma, args)
+	if err != nil {
+		respFactory.Error(rw, err)
+		return
+	}
+	h.templates.ExecuteTemplate(rw, "import_customers", &ImportCustomersPageData{
+		CsrfToken:   csrfToken(r),
+		DryRun:      args.DryRun,
+		SkipInvalid: args.SkipInvalid,
+		Rules:       h.rules(),
+		Result:      result,
+		Nav:         h.nav(r),
+	})
+}
+
+// Api receives file as request body, options are passed in query string:
+// format(csv or tsv, content type is used if it's missing), dryRun and skipInvalid.
+func (h *handler) apiImportCustomers(rw http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	comma, ok := importComma(query.Get("format"), "", r.Header.Get("Content-Type"))
+	if !ok {
+		apiRespFactory.CodeMessage(rw, codes.InvalidData, codes.KnownMessageImportMalformedFile)
+		return
+	}
+	args := &dto.ImportCustomersArguments{
+		DryRun:      query.Get("dryRun") == "true",
+		SkipInvalid: query.Get("skipInvalid") == "true",
+	}
+	result, err := h.importCustomers(r, r.Body, comma, args)
+	if err != nil {
+		apiRespFactory.Error(rw, err)
+		return
+	}
+	resp.Json(rw, http.StatusOK, result)
+}
+
+func (h *handler) importCustomers(r *http.Request, file io.Reader, comma rune, args *dto.ImportCustomersArguments) (*dto.ImportCustomersResult, error) {
+	rows, err := customerservice.ReadImportFile(file, comma)
+	if err != nil {
+		return nil, err
+	}
+	args.Rows = rows
+	return h.customerService.Import(r.Context(), args)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abdybaevae/customers-app/conf"
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/ratelimit"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+)
+
+// routes with their own body limit, every other route has server.max_body_size
+func bodyLimits(cfg *conf.ServerConfig) map[string]int64 {
+	return map[string]int64{
+		"/customers/import":        cfg.MaxImportSize,
+		"/api/v1/customers/import": cfg.MaxImportSize,
+	}
+}
+
+// Body of every request is limited before it's read by anything(csrf check reads form too). Request with
+// known too large length is rejected at once, body without length fails on read after limit.
+func limitBodies(next http.Handler, cfg *conf.ServerConfig) http.Handler {
+	limits := bodyLimits(cfg)
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		limit, ok := limits[r.URL.Path]
+		if !ok {
+			limit = cfg.MaxBodySize
+		}
+		if r.ContentLength > limit {
+			if isApiRequest(r) {
+				apiRespFactory.CodeMessage(rw, codes.RequestTooLarge, codes.KnownMessageRequestTooLarge)
+			} else {
+				respFactory.CodeMessage(rw, codes.RequestTooLarge, codes.KnownMessageRequestTooLarge)
+			}
+			return
+		}
+		r.Body = http.MaxBytesReader(rw, r.Body, limit)
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Router middleware before authentication, every address has bucket shared by all its clients. Requests with
+// missing or wrong credentials are limited by it too, so they can't make unlimited authentication lookups.
+func (h *handler) limitAddress(next http.Handler) http.Handler {
+	cfg := h.Cfg.RateLimit
+	limit := ratelimit.Limit{Rate: cfg.AddressRate, Burst: cfg.AddressBurst}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !cfg.Enabled || isUnlimitedPath(r.URL.Path) || h.takeToken(rw, r, "address:"+clientIp(r), limit) {
+			next.ServeHTTP(rw, r)
+		}
+	})
+}
+
+// Router middleware after authentication, so clients are limited by their api key or user and only anonymous
+// ones(login form) by address. Probes, metrics and static files aren't limited.
+func (h *handler) rateLimit(next http.Handler) http.Handler {
+	cfg := h.Cfg.RateLimit
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !cfg.Enabled || isUnlimitedPath(r.URL.Path) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		limit, class := ratelimit.Limit{Rate: cfg.Rate, Burst: cfg.Burst}, "read"
+		if !isSafeMethod(r.Method) {
+			limit, class = ratelimit.Limit{Rate: cfg.WriteRate, Burst: cfg.WriteBurst}, "write"
+		}
+		if h.takeToken(rw, r, class+":"+rateLimitClient(r), limit) {
+			next.ServeHTTP(rw, r)
+		}
+	})
+}
+
+// Token is taken from bucket of key, request without token is rejected and false is returned.
+func (h *handler) takeToken(rw http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+	allowed, retryAfter, err := h.rateLimits.Take(r.Context(), key, limit)
+	if err != nil {
+		// store outage doesn't stop the service
+		reqinfo.Logger(r.Context(), h.log).Warnf("rate limit wasn't checked: %v", err)
+		return true
+	}
+	if !allowed {
+		rw.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+		if isApiRequest(r) {
+			apiRespFactory.CodeMessage(rw, codes.TooManyRequests, codes.KnownMessageTooManyRequests)
+		} else {
+			respFactory.CodeMessage(rw, codes.TooManyRequests, codes.KnownMessageTooManyRequests)
+		}
+	}
+	return allowed
+}
+
+// public paths except login form, which is the main target of password guessing, and metrics scraped by monitoring
+func isUnlimitedPath(path string) bool {
+	return path == metricsPath || path != "/login" && path != "/logout" && isPublicPath(path)
+}
+
+// bucket key of request client
+func rateLimitClient(r *http.Request) string {
+	if apiKey := currentApiKey(r); apiKey != nil {
+		return "key:" + apiKey.Prefix
+	}
+	if user := currentUser(r); user != nil {
+		return "user:" + strings.ToLower(user.Login)
+	}
+	return "ip:" + clientIp(r)
+}
+
+// header has whole seconds, client mustn't retry before token appears
+func retryAfterSeconds(retryAfter time.Duration) int {
+	seconds := math.Ceil(retryAfter.Seconds())
+	if seconds < 1 {
+		return 1
+	}
+	if seconds > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(seconds)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdybaevae/customers-app/conf"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/ratelimit"
+	"github.com/sirupsen/logrus"
+)
+
+func newLimitedHandler(store ratelimit.Store) http.Handler {
+	cfg := conf.Default()
+	cfg.RateLimit.Burst = 2
+	service := newFakeService()
+	service.customers[1] = &models.Customer{Id: 1, FirstName: "John", Hash: "hash"}
+	return NewHandler(service, newFakeUserService(), newFakeApiKeyService(), cfg, logrus.NewEntry(logrus.New()), Options{RateLimitStore: store})
+}
+
+func doLimitedRequest(h http.Handler, target, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if strings.HasPrefix(user, "cak_") {
+		req.Header.Set("Authorization", "Bearer "+user)
+	} else {
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: user + "-token"})
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimit(t *testing.T) {
+	h := newLimitedHandler(nil)
+	for i := 0; i < 2; i++ {
+		if rec := doLimitedRequest(h, "/api/v1/customers/1", "viewer"); rec.Code != http.StatusOK {
+			t.Fatal("burst must be allowed", i, rec.Code)
+		}
+	}
+	rec := doLimitedRequest(h, "/api/v1/customers/1", "viewer")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" || !strings.Contains(rec.Body.String(), `"TooManyRequests"`) {
+		t.Error("request above burst must be rejected", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+	if rec := doLimitedRequest(h, "/customers/1/history", "viewer"); rec.Code != http.StatusTooManyRequests {
+		t.Error("pages and api share bucket of user", rec.Code)
+	}
+	for _, client := range []string{"editor", "cak_customers:read"} {
+		if rec := doLimitedRequest(h, "/api/v1/customers/1", client); rec.Code != http.StatusOK {
+			t.Error("clients must have separate buckets", client, rec.Code)
+		}
+	}
+	if rec := doLimitedRequest(h, "/healthz", "viewer"); rec.Code != http.StatusOK {
+		t.Error("probes must not be limited", rec.Code)
+	}
+}
+
+// invalid api keys are rejected by authentication, but they are limited by address before it
+func TestRateLimitOfFailedAuthentication(t *testing.T) {
+	cfg := conf.Default()
+	cfg.RateLimit.AddressBurst = 2
+	h := NewHandler(newFakeService(), newFakeUserService(), newFakeApiKeyService(), cfg, logrus.NewEntry(logrus.New()), Options{})
+	for i := 0; i < 2; i++ {
+		if rec := doLimitedRequest(h, "/api/v1/customers/1", "cak_wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatal("wrong key must be rejected by authentication", i, rec.Code)
+		}
+	}
+	if rec := doLimitedRequest(h, "/api/v1/customers/1", "cak_wrong"); rec.Code != http.StatusTooManyRequests {
+		t.Error("failed authentication must be limited", rec.Code)
+	}
+	if rec := doLimitedRequest(h, "/api/v1/customers/1", "cak_customers:read"); rec.Code != http.StatusTooManyRequests {
+		t.Error("address bucket is shared by all clients of address", rec.Code)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (bool, time.Duration, error) {
+	return false, 0, errors.New("store is down")
+}
+
+func TestRateLimitStoreFailure(t *testing.T) {
+	h := newLimitedHandler(failingStore{})
+	for i := 0; i < 3; i++ {
+		if rec := doLimitedRequest(h, "/api/v1/customers/1", "viewer"); rec.Code != http.StatusOK {
+			t.Error("requests must be allowed without store", rec.Code)
+		}
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	cfg := &conf.ServerConfig{MaxBodySize: 10, MaxImportSize: 100}
+	var read int
+	var readErr error
+	h := limitBodies(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var body []byte
+		body, readErr = ioutil.ReadAll(r.Body)
+		read = len(body)
+	}), cfg)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/customers", strings.NewReader(strings.Repeat("x", 11))))
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), `"RequestTooLarge"`) {
+		t.Error("body of known length above limit must be rejected", rec.Code, rec.Body)
+	}
+	// length of chunked body isn't known before it's read
+	req := httptest.NewRequest(http.MethodPost, "/customers/1/edit", strings.NewReader(strings.Repeat("x", 11)))
+	req.ContentLength = -1
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if readErr == nil || read > 10 {
+		t.Error("body must be cut at limit", read, readErr)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/customers/import", strings.NewReader(strings.Repeat("x", 50))))
+	if rec.Code != http.StatusOK || readErr != nil || read != 50 {
+		t.Error("import must have its own limit", rec.Code, read, readErr)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/urfave/negroni"
+)
+
+// One structured line per request. Query string isn't logged, it can contain searched names and emails.
+func accessLog(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+	next(rw, r)
+	nrw := rw.(negroni.ResponseWriter)
+	status := nrw.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	entry := reqinfo.Logger(r.Context(), nil).WithFields(map[string]interface{}{
+		"method":      r.Method,
+		"path":        r.URL.Path,
+		"status":      status,
+		"size":        nrw.Size(),
+		"duration_ms": time.Since(start).Milliseconds(),
+		"remote":      clientIp(r),
+		"user_agent":  r.UserAgent(),
+	})
+	if status >= http.StatusInternalServerError {
+		entry.Error("request failed")
+	} else {
+		entry.Info("request handled")
+	}
+}
+
+// Panic of handler is logged with stack and client receives ServerInternal message(html page or json for api).
+// Response can't be replaced if handler already started writing it, then connection is just closed.
+func recoverPanic(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		if recovered == http.ErrAbortHandler {
+			panic(recovered)
+		}
+		reqinfo.Logger(r.Context(), nil).WithField("panic", recovered).Errorf("handler panicked\n%s", debug.Stack())
+		if rw.(negroni.ResponseWriter).Written() {
+			panic(http.ErrAbortHandler)
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			apiRespFactory.CodeMessage(rw, codes.ServerInternal, codes.KnownMessageSomethingWrongHappened)
+		} else {
+			respFactory.CodeMessage(rw, codes.ServerInternal, codes.KnownMessageSomethingWrongHappened)
+		}
+	}()
+	next(rw, r)
+}
+
+// pages use inline styles and handlers, everything else must come from the server itself
+const contentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; frame-ancestors 'none'; base-uri 'self'; form-action 'self'"
+
+func securityHeaders(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	header := rw.Header()
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("X-Frame-Options", "DENY")
+	header.Set("Referrer-Policy", "same-origin")
+	header.Set("Content-Security-Policy", contentSecurityPolicy)
+	if r.TLS != nil {
+		header.Set("Strict-Transport-Security", "max-age=31536000")
+	}
+	next(rw, r)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/sirupsen/logrus"
+)
+
+// logger writing json lines to buffer
+func newBufferLog() (*logrus.Entry, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	return logrus.NewEntry(logger), &buf
+}
+
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	lines := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestRequestIdPropagation(t *testing.T) {
+	log, buf := newBufferLog()
+	var contextId string
+	h := withMiddlewares(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		contextId = reqinfo.RequestId(r.Context())
+		reqinfo.Logger(r.Context(), nil).Info("inside service")
+	}), log)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestIdHeader, "lb-42")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if contextId != "lb-42" || rec.Header().Get(requestIdHeader) != "lb-42" {
+		t.Error("request id of client must be kept", contextId, rec.Header().Get(requestIdHeader))
+	}
+	lines := logLines(t, buf)
+	if len(lines) != 2 || lines[0]["request_id"] != "lb-42" || lines[1]["request_id"] != "lb-42" {
+		t.Fatal("service and access log lines must have request id", lines)
+	}
+	if lines[1]["status"] != float64(http.StatusOK) || lines[1]["path"] != "/" {
+		t.Error("unexpected access log", lines[1])
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestIdHeader, "bad id\nforged log line")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if generated := rec.Header().Get(requestIdHeader); generated == "" || strings.Contains(generated, "forged") || contextId != generated {
+		t.Error("unsafe request id must be replaced", generated)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	log, buf := newBufferLog()
+	h := withMiddlewares(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		panic("broken handler")
+	}), log)
+	for _, path := range []string{"/customers/add", "/api/v1/customers"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "Something went wrong") {
+			t.Error("panic must render server error", path, rec.Code, rec.Body.String())
+		}
+		if strings.HasPrefix(path, "/api/") != strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+			t.Error("api must receive json error", path, rec.Header().Get("Content-Type"))
+		}
+	}
+	if !strings.Contains(buf.String(), "broken handler") || !strings.Contains(buf.String(), "request_id") {
+		t.Error("panic must be logged with request id", buf.String())
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	log, _ := newBufferLog()
+	rec := httptest.NewRecorder()
+	withMiddlewares(http.NotFoundHandler(), log).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	for _, header := range []string{"X-Content-Type-Options", "X-Frame-Options", "Referrer-Policy", "Content-Security-Policy"} {
+		if rec.Header().Get(header) == "" {
+			t.Error("missing security header", header)
+		}
+	}
+}
+
+func TestGzip(t *testing.T) {
+	log, _ := newBufferLog()
+	big := strings.Repeat("customer,", 1000)
+	tt := []struct {
+		name        string
+		contentType string
+		body        string
+		compressed  bool
+	}{
+		{"big page", "text/html; charset=utf-8", big, true},
+		{"small page", "text/html; charset=utf-8", "ok", false},
+		{"xlsx export", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", big, false},
+	}
+	for _, tc := range tt {
+		h := withMiddlewares(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw.Header().Set("Content-Type", tc.contentType)
+			for i := 0; i < len(tc.body); i += 100 {
+				end := i + 100
+				if end > len(tc.body) {
+					end = len(tc.body)
+				}
+				rw.Write([]byte(tc.body[i:end]))
+			}
+		}), log)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "deflate, gzip;q=1.0")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		compressed := rec.Header().Get("Content-Encoding") == "gzip"
+		if compressed != tc.compressed {
+			t.Errorf("%s: compressed %v", tc.name, compressed)
+			continue
+		}
+		body := rec.Body.Bytes()
+		if compressed {
+			gz, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ = ioutil.ReadAll(gz)
+		}
+		if string(body) != tc.body {
+			t.Errorf("%s: body was changed", tc.name)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"regexp"
+
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/negroni"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+// request id of client(e.g. load balancer) is kept only if it's safe to put into logs and headers
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,100}$`)
+
+// Put request id, logger with request id and actor to request context, services and repositories read them
+// from there(e.g. for audit records). Request id is returned in response header.
+func requestInfo(log *logrus.Entry) negroni.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		requestId := r.Header.Get(requestIdHeader)
+		if !validRequestId.MatchString(requestId) {
+			requestId = newRequestId()
+		}
+		rw.Header().Set(requestIdHeader, requestId)
+		ctx := reqinfo.WithRequestId(r.Context(), requestId)
+		ctx = reqinfo.WithLogger(ctx, log.WithContext(ctx).WithField("request_id", requestId))
+		// actor is replaced with user login after authentication, login attempts are made by client address
+		ctx = reqinfo.WithActor(ctx, "anonymous@"+clientIp(r))
+		next(rw, r.WithContext(ctx))
+	}
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,31 +1,96 @@
 package server
 
 import (
+	"context"
+	"io/fs"
 	"net/http"
 
+	"github.com/abdybaevae/customers-app/conf"
+	"github.com/abdybaevae/customers-app/pkg/metrics"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/ratelimit"
+	apikeyservice "github.com/abdybaevae/customers-app/pkg/services/apikey"
 	customerservice "github.com/abdybaevae/customers-app/pkg/services/customer"
+	idempotencyservice "github.com/abdybaevae/customers-app/pkg/services/idempotency"
+	userservice "github.com/abdybaevae/customers-app/pkg/services/user"
 	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/abdybaevae/customers-app/ui"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
-	_ "github.com/urfave/negroni"
+	"github.com/urfave/negroni"
 )
 
-func NewHandler(customerService customerservice.CustomerService, log *logrus.Entry) http.Handler {
+// Optional parts of handler, they are set by main
+type Options struct {
+	// readiness check of /readyz, server is always ready without it
+	Ready func(ctx context.Context) error
+	// metrics served on /metrics, requests of every route are measured with it
+	Metrics *metrics.Registry
+	// buckets of rate limit, in-memory store is used without it
+	RateLimitStore ratelimit.Store
+	// stored responses of requests with Idempotency-Key header, header is ignored without it
+	Idempotency idempotencyservice.IdempotencyService
+}
+
+func NewHandler(customerService customerservice.CustomerService, userService userservice.UserService, apiKeyService apikeyservice.ApiKeyService,
+	cfg *conf.Config, log *logrus.Entry, opts Options) http.Handler {
 	router := mux.NewRouter()
 	templates := utils.LoadTemplates()
 	h := &handler{
 		customerService: customerService,
+		userService:     userService,
+		apiKeyService:   apiKeyService,
 		templates:       templates,
 		log:             log,
+		Cfg:             cfg,
+		ready:           opts.Ready,
+		rateLimits:      opts.RateLimitStore,
+		idempotency:     opts.Idempotency,
+	}
+	if h.rateLimits == nil {
+		h.rateLimits = ratelimit.NewMemoryStore()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.NewRegistry()
 	}
-	fs := http.FileServer(http.Dir("./ui/static"))
-	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fs))
+	router.Use(h.limitAddress, h.authenticate, h.rateLimit)
+	router.HandleFunc("/healthz", h.healthz).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", h.readyz).Methods(http.MethodGet)
+	router.HandleFunc(metricsPath, h.requireScope(models.ScopeMetricsRead, opts.Metrics.Handler().ServeHTTP)).Methods(http.MethodGet)
+	static, err := fs.Sub(ui.Files(), "static")
+	if err != nil {
+		panic(err)
+	}
+	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+	router.HandleFunc("/login", h.loginPage).Methods(http.MethodGet)
+	router.HandleFunc("/login", h.handleLogin).Methods(http.MethodPost)
+	router.HandleFunc("/logout", h.handleLogout).Methods(http.MethodPost)
 	router.HandleFunc("/", h.showListPage).Methods(http.MethodGet)
 	router.HandleFunc("/", h.queryList).Methods(http.MethodPost)
-	router.HandleFunc("/customers/add", h.addCustomerPage).Methods(http.MethodGet)
-	router.HandleFunc("/customers/add", h.handleAddCustomer).Methods(http.MethodPost)
-	router.HandleFunc("/customers/{customerId}/edit", h.editCustomerPage).Methods(http.MethodGet)
-	router.HandleFunc("/customers/{customerId}/edit", h.handleUpdateCustomer).Methods(http.MethodPost)
-	router.HandleFunc("/customers/{customerId}/delete", h.handleDeleteCustomer).Methods(http.MethodPost)
-	return router
+	router.HandleFunc("/customers/add", h.requireScope(models.ScopeCustomersWrite, h.addCustomerPage)).Methods(http.MethodGet)
+	router.HandleFunc("/customers/add", h.requireScope(models.ScopeCustomersWrite, h.idempotent(h.handleAddCustomer))).Methods(http.MethodPost)
+	router.HandleFunc("/customers/export", h.exportCustomers).Methods(http.MethodGet)
+	router.HandleFunc("/customers/import", h.requireScope(models.ScopeCustomersWrite, h.importCustomersPage)).Methods(http.MethodGet)
+	router.HandleFunc("/customers/import", h.requireScope(models.ScopeCustomersWrite, h.handleImportCustomers)).Methods(http.MethodPost)
+	router.HandleFunc("/customers/{customerId}/edit", h.requireScope(models.ScopeCustomersWrite, h.editCustomerPage)).Methods(http.MethodGet)
+	router.HandleFunc("/customers/{customerId}/edit", h.requireScope(models.ScopeCustomersWrite, h.idempotent(h.handleUpdateCustomer))).Methods(http.MethodPost)
+	router.HandleFunc("/customers/{customerId}/delete", h.requireScope(models.ScopeCustomersDelete, h.idempotent(h.handleDeleteCustomer))).Methods(http.MethodPost)
+	router.HandleFunc("/customers/{customerId}/history", h.customerHistoryPage).Methods(http.MethodGet)
+	router.HandleFunc("/customers/deleted", h.deletedListPage).Methods(http.MethodGet)
+	router.HandleFunc("/customers/{customerId}/restore", h.requireScope(models.ScopeCustomersWrite, h.handleRestoreCustomer)).Methods(http.MethodPost)
+	registerApiRoutes(router, h)
+	return withMiddlewares(measureRequests(limitBodies(csrfProtect(router), &cfg.Server), router, opts.Metrics), log)
+}
+
+// Middlewares shared by all routes, request id goes first, so access log and panic log lines have it
+func withMiddlewares(next http.Handler, log *logrus.Entry) http.Handler {
+	chain := negroni.New(
+		requestInfo(log),
+		negroni.HandlerFunc(accessLog),
+		negroni.HandlerFunc(recoverPanic),
+		negroni.HandlerFunc(securityHeaders),
+		negroni.HandlerFunc(gzipResponses),
+	)
+	chain.UseHandler(next)
+	return chain
 }
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Serve requests from listener till ctx is done(main cancels it on SIGINT or SIGTERM). Then listener is closed
+// and in-flight requests are drained up to drainTimeout, connections still busy after it are closed.
+// Https is served if both tls files are given. Graceful stop isn't an error.
+func Serve(ctx context.Context, srv *http.Server, l net.Listener, tlsCertFile, tlsKeyFile string, drainTimeout time.Duration, log *logrus.Entry) error {
+	served := make(chan error, 1)
+	go func() {
+		if tlsCertFile != "" {
+			served <- srv.ServeTLS(l, tlsCertFile, tlsKeyFile)
+		} else {
+			served <- srv.Serve(l)
+		}
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	log.Infof("shutting down, waiting up to %v for in-flight requests", drainTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	err := srv.Shutdown(drainCtx)
+	if err != nil {
+		log.Warnf("requests weren't drained in time, closing connections: %v", err)
+		srv.Close()
+	}
+	if serveErr := <-served; serveErr != http.ErrServerClosed {
+		return serveErr
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// server is started the same way as main does it, slow request started before SIGTERM must be completed
+func TestServeDrainsRequestsOnSigterm(t *testing.T) {
+	stop, cancelStop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer cancelStop()
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-time.After(300 * time.Millisecond):
+			rw.Write([]byte("done"))
+		case <-r.Context().Done():
+			t.Error("in-flight request was cancelled")
+		}
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(stop, srv, l, "", "", 5*time.Second, logrus.NewEntry(logrus.New()))
+	}()
+
+	url := "http://" + l.Addr().String() + "/slow"
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		responses <- result{string(body), err}
+	}()
+	<-started
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	res := <-responses
+	if res.err != nil || res.body != "done" {
+		t.Fatal("slow request wasn't completed", res.err, res.body)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Error("graceful stop must not be error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop")
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("stopped server must not accept requests")
+	}
+}
+
+func TestServeClosesRequestsAfterDrainTimeout(t *testing.T) {
+	stop, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(stop, srv, l, "", "", 100*time.Millisecond, logrus.NewEntry(logrus.New()))
+	}()
+	go http.Get("http://" + l.Addr().String())
+	<-started
+	cancel()
+	select {
+	case err := <-served:
+		if err != context.DeadlineExceeded {
+			t.Error("missed drain deadline must be reported", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop after drain deadline")
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/abdybaevae/customers-app/conf"
+	"github.com/abdybaevae/customers-app/pkg/custval"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// values typed by users are rendered into every page, none of them must break out of its html context
+var hostileValues = []string{
+	`<script>alert(1)</script>`,
+	`"><script>alert(1)</script>`,
+	`' onmouseover='alert(1)`,
+	`" autofocus onfocus="alert(1)`,
+	`javascript:alert(1)`,
+	`</textarea><img src=x onerror=alert(1)>`,
+}
+
+// markers that appear in rendered page only if hostile value wasn't escaped
+var injectionMarkers = []string{
+	"<script>alert",
+	"<img src=x",
+	`" autofocus`,
+	`' onmouseover`,
+}
+
+func renderTemplate(t *testing.T, name string, data interface{}) string {
+	var buf bytes.Buffer
+	if err := utils.LoadTemplates().ExecuteTemplate(&buf, name, data); err != nil {
+		t.Fatalf("cannot render %s: %v", name, err)
+	}
+	return buf.String()
+}
+
+// url attribute which runs script, html/template renders unsafe url as #ZgotmplZ instead
+var scriptUrl = regexp.MustCompile(`(?i)(href|src|action)\s*=\s*["']?\s*javascript:`)
+
+func assertEscaped(t *testing.T, name, page, value string) {
+	for _, marker := range injectionMarkers {
+		if strings.Contains(value, marker) && strings.Contains(page, marker) {
+			t.Errorf("%s: value %q rendered without escaping", name, value)
+		}
+	}
+	if scriptUrl.MatchString(page) {
+		t.Errorf("%s: value %q rendered as script url", name, value)
+	}
+}
+
+func TestCustomersListEscaping(t *testing.T) {
+	for _, value := range hostileValues {
+		page := renderTemplate(t, "customers_list", &queryListData{
+			Customers: []dto.ListCustomerResultItem{{
+				Id:        1,
+				Email:     value,
+				FirstName: value,
+				LastName:  value,
+				Address:   value,
+				BirthDate: value,
+				Gender:    value,
+			}},
+			Next:         true,
+			Prev:         true,
+			SearchValue:  value,
+			OrderBy:      value,
+			OrderByValue: value,
+			CsrfToken:    value,
+		})
+		assertEscaped(t, "customers_list", page, value)
+	}
+}
+
+func TestEditCustomerEscaping(t *testing.T) {
+	for _, value := range hostileValues {
+		page := renderTemplate(t, "edit_customer", &EditCustomerPageData{
+			Id:        1,
+			FirstName: value,
+			LastName:  value,
+			BirthDate: value,
+			Gender:    value,
+			Email:     value,
+			Address:   value,
+			Hash:      value,
+			MinDate:   value,
+			MaxDate:   value,
+			CsrfToken: value,
+			Rules:     custval.Rules{Genders: []string{value}},
+			Original:  customerFormValues{value, value, value, value, value, value},
+			Conflict:  []conflictField{{value, value, value, value, true, true}},
+		})
+		assertEscaped(t, "edit_customer", page, value)
+	}
+}
+
+func TestFormsFollowRules(t *testing.T) {
+	rules := custval.Rules{Genders: []string{"female", "male", "unspecified"}, MaxNameLength: 42}
+	page := renderTemplate(t, "edit_customer", &EditCustomerPageData{Gender: "unspecified", Rules: rules})
+	if !strings.Contains(page, `<option value="unspecified" selected>`) || !strings.Contains(page, `maxlength="42"`) {
+		t.Error("edit page must offer configured genders and lengths")
+	}
+	page = renderTemplate(t, "create_customer", &AddCustomerPageData{Rules: rules})
+	if !strings.Contains(page, `<option value="unspecified">`) {
+		t.Error("create page must offer configured genders")
+	}
+}
+
+func TestCreateCustomerEscaping(t *testing.T) {
+	for _, value := range hostileValues {
+		page := renderTemplate(t, "create_customer", &AddCustomerPageData{
+			MinDate:   value,
+			MaxDate:   value,
+			CsrfToken: value,
+			Rules:     custval.Rules{Genders: []string{value}},
+		})
+		assertEscaped(t, "create_customer", page, value)
+	}
+}
+
+func TestMessageEscaping(t *testing.T) {
+	for _, value := range hostileValues {
+		page := renderTemplate(t, "message", map[string]interface{}{
+			"Message":   value,
+			"IsSuccess": false,
+		})
+		assertEscaped(t, "message", page, value)
+	}
+}
+
+// hidden hash field is used for optimistic lock, escaping must keep its value readable by browser
+func TestHiddenHashAttribute(t *testing.T) {
+	page := renderTemplate(t, "edit_customer", &EditCustomerPageData{Id: 7, Hash: `a"b<c`})
+	if !strings.Contains(page, `name="hash" value="a&#34;b&lt;c"`) {
+		t.Error("hash attribute wasn't escaped properly")
+	}
+	if !strings.Contains(page, `action="/customers/7/edit"`) {
+		t.Error("edit url was rendered incorrectly")
+	}
+}
+
+func TestCustomerHistoryEscaping(t *testing.T) {
+	for _, value := range hostileValues {
+		page := renderTemplate(t, "customer_history", &CustomerHistoryPageData{
+			Id: 1,
+			Entries: []historyEntry{{
+				Action:    value,
+				Actor:     value,
+				RequestId: value,
+				CreatedAt: value,
+				Changes:   []historyChange{{Field: value, Before: value, After: value}},
+			}},
+		})
+		assertEscaped(t, "customer_history", page, value)
+	}
+}
+
+func TestDeletedCustomersEscaping(t *testing.T) {
+	for _, value := range hostileValues {
+		page := renderTemplate(t, "deleted_customers", &deletedListData{
+			Customers: []dto.ListCustomerResultItem{{
+				Id:        1,
+				Email:     value,
+				FirstName: value,
+				LastName:  value,
+				Address:   value,
+				BirthDate: value,
+				Gender:    value,
+			}},
+			Next:       true,
+			Prev:       true,
+			NextCursor: value,
+			PrevCursor: value,
+			CsrfToken:  value,
+		})
+		assertEscaped(t, "deleted_customers", page, value)
+	}
+}
+
+func TestImportCustomersEscaping(t *testing.T) {
+	for _, value := range hostileValues {
+		page := renderTemplate(t, "import_customers", &ImportCustomersPageData{
+			CsrfToken: value,
+			Result: &dto.ImportCustomersResult{
+				Total:  1,
+				Errors: []dto.ImportRowError{{Line: 2, Email: value, Message: value}},
+			},
+		})
+		assertEscaped(t, "import_customers", page, value)
+	}
+}
+
+// static files are embedded into binary together with templates
+func TestStaticFiles(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHandler(newFakeService(), newFakeUserService(), newFakeApiKeyService(), conf.Default(), logrus.NewEntry(logrus.New()), Options{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/jquery-3.6.0.min.js", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Error("static file wasn't served", rec.Code)
+	}
+}
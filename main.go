@@ -1,60 +1,337 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/abdybaevae/customers-app/conf"
+	"github.com/abdybaevae/customers-app/internal/jobs"
+	"github.com/abdybaevae/customers-app/internal/seed"
 	"github.com/abdybaevae/customers-app/internal/server"
+	"github.com/abdybaevae/customers-app/ui"
 
 	"github.com/abdybaevae/customers-app/internal/db"
+	"github.com/abdybaevae/customers-app/pkg/cache"
+	"github.com/abdybaevae/customers-app/pkg/metrics"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	apikeyrepo "github.com/abdybaevae/customers-app/pkg/repos/apikey"
 	customerrepo "github.com/abdybaevae/customers-app/pkg/repos/customer"
+	idempotencyrepo "github.com/abdybaevae/customers-app/pkg/repos/idempotency"
+	userrepo "github.com/abdybaevae/customers-app/pkg/repos/user"
+	apikeyservice "github.com/abdybaevae/customers-app/pkg/services/apikey"
 	customerservice "github.com/abdybaevae/customers-app/pkg/services/customer"
+	idempotencyservice "github.com/abdybaevae/customers-app/pkg/services/idempotency"
+	userservice "github.com/abdybaevae/customers-app/pkg/services/user"
 
-	_ "github.com/brianvoe/gofakeit/v6"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
 )
 
+// Binary starts web server by default, other commands are given as first argument:
+//
+//	seed -count 1000 -seed 1 -batch 500	fill database with fake customers
+//	migrate up|down N|goto V|version|force V	manage database schema, server requires the latest one
+//	config print	print effective configuration with hidden secrets
+//	user add|passwd|role|list -login L -role R	manage users of customer ui, password is read from stdin
+//	apikey create|revoke|list -name N -scopes S -lifetime D -id I	manage api keys of machine clients
 func main() {
-	// define root context that can be cancelled with releasing all resources
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "seed":
+			seedCommand(os.Args[2:])
+			return
+		case "migrate":
+			migrateCommand(os.Args[2:])
+			return
+		case "config":
+			configCommand(os.Args[2:])
+			return
+		case "user":
+			userCommand(os.Args[2:])
+			return
+		case "apikey":
+			apiKeyCommand(os.Args[2:])
+			return
+		}
+	}
+	serve()
+}
+
+// invalid configuration stops any command, all problems are printed at once
+func loadConfig() *conf.Config {
+	cfg, err := conf.Load()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	cfg.Log.Apply(logrus.StandardLogger())
+	return cfg
+}
+
+func configCommand(args []string) {
+	if len(args) != 1 || args[0] != "print" {
+		logrus.Fatal("config command is required: print")
+	}
+	settings, err := conf.Settings()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	out, err := yaml.Marshal(settings)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	os.Stdout.Write(out)
+}
+
+func seedCommand(args []string) {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	opts := seed.Options{}
+	flags.IntVar(&opts.Count, "count", 1000, "count of created customers")
+	flags.Int64Var(&opts.Seed, "seed", 1, "random seed, the same seed generates the same customers")
+	flags.IntVar(&opts.BatchSize, "batch", 500, "customers inserted in one transaction")
+	flags.Parse(args)
+	cfg := loadConfig()
+	log := logrus.NewEntry(logrus.StandardLogger())
+	dbConn := db.Connect(cfg)
+	defer dbConn.Close()
+	opts.Rules = cfg.Customers.Rules()
+	if err := seed.Run(context.Background(), customerrepo.New(dbConn), opts, log); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func userCommand(args []string) {
+	if len(args) == 0 {
+		logrus.Fatal("user command is required: add, passwd, role or list")
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("user "+command, flag.ExitOnError)
+	login := flags.String("login", "", "user login")
+	role := flags.String("role", "", "user role: viewer, editor or admin(new user is viewer by default)")
+	flags.Parse(args[1:])
+	cfg := loadConfig()
+	log := logrus.NewEntry(logrus.StandardLogger())
+	dbConn := db.Connect(cfg)
+	defer dbConn.Close()
+	userService := userservice.New(userrepo.New(dbConn), log, userservice.Options{SessionLifetime: cfg.Auth.SessionLifetime})
+	ctx := context.Background()
+	var err error
+	switch command {
+	case "add":
+		if *role == "" {
+			*role = string(models.RoleViewer)
+		}
+		var password string
+		if password, err = readPassword(); err == nil {
+			_, err = userService.Create(ctx, *login, password, models.Role(*role))
+		}
+	case "passwd":
+		var password string
+		if password, err = readPassword(); err == nil {
+			err = userService.SetPassword(ctx, *login, password)
+		}
+	case "role":
+		err = userService.SetRole(ctx, *login, models.Role(*role))
+	case "list":
+		var users []models.User
+		if users, err = userService.List(ctx); err == nil {
+			for _, user := range users {
+				fmt.Printf("%s\t%s\t%s\n", user.Login, user.Role, user.CreatedAt.Format("2006-01-02 15:04:05"))
+			}
+		}
+	default:
+		log.Fatalf("unknown user command %q", command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func apiKeyCommand(args []string) {
+	if len(args) == 0 {
+		logrus.Fatal("apikey command is required: create, revoke or list")
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("apikey "+command, flag.ExitOnError)
+	name := flags.String("name", "", "name of client which uses key")
+	scopes := flags.String("scopes", string(models.ScopeCustomersRead), "comma separated scopes: customers:read, customers:write, customers:delete, metrics:read")
+	lifetime := flags.Duration("lifetime", 0, "key expires after lifetime, zero means key doesn't expire")
+	id := flags.Int("id", 0, "id of revoked key")
+	flags.Parse(args[1:])
+	cfg := loadConfig()
+	log := logrus.NewEntry(logrus.StandardLogger())
+	dbConn := db.Connect(cfg)
+	defer dbConn.Close()
+	apiKeyService := apikeyservice.New(apikeyrepo.New(dbConn), log)
+	ctx := context.Background()
+	var err error
+	switch command {
+	case "create":
+		keyScopes := []models.Scope{}
+		for _, scope := range strings.Split(*scopes, ",") {
+			keyScopes = append(keyScopes, models.Scope(strings.TrimSpace(scope)))
+		}
+		var key string
+		var apiKey *models.ApiKey
+		if key, apiKey, err = apiKeyService.Create(ctx, *name, keyScopes, *lifetime); err == nil {
+			// key can't be shown again, only its hash is stored
+			fmt.Fprintf(os.Stderr, "api key %d was created, keep it secret, it won't be shown again:\n", apiKey.Id)
+			fmt.Println(key)
+		}
+	case "revoke":
+		err = apiKeyService.Revoke(ctx, *id)
+	case "list":
+		var keys []models.ApiKey
+		if keys, err = apiKeyService.List(ctx); err == nil {
+			for _, key := range keys {
+				fmt.Printf("%d\t%s\t%s\t%s\texpires %s\tlast used %s\t%s\n", key.Id, key.Prefix, key.Name, strings.Join(key.Scopes, ","),
+					formatOptionalTime(key.ExpiresAt), formatOptionalTime(key.LastUsedAt), keyStatus(&key))
+			}
+		}
+	default:
+		log.Fatalf("unknown apikey command %q", command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func keyStatus(key *models.ApiKey) string {
+	switch {
+	case key.RevokedAt != nil:
+		return "revoked"
+	case !key.IsActive(time.Now()):
+		return "expired"
+	}
+	return "active"
+}
+
+// password is read from first line of stdin, so it doesn't get to shell history or process list
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("password must be given in stdin")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func migrateCommand(args []string) {
+	cfg := loadConfig()
+	log := logrus.NewEntry(logrus.StandardLogger())
+	if err := db.Migrate(cfg, args, log); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// expired sessions and idempotency keys are ignored anyway, they are removed only to keep tables small
+const (
+	sessionPurgeInterval     = time.Hour
+	idempotencyPurgeInterval = time.Hour
+)
+
+func serve() {
+	cfg := loadConfig()
+	// stop is cancelled by signal: server stops accepting requests and background jobs stop
+	stop, cancelStop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancelStop()
+	// requests live in separate context, so they aren't cancelled by signal while they are drained
 	ctx, cancel := context.WithCancel(context.Background())
-	log := logrus.WithContext(ctx)
 	defer cancel()
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	log := logrus.WithContext(ctx)
 
-	cfg := conf.Load()
 	dbConn := db.Connect(cfg)
+	defer dbConn.Close()
 
 	customerRepo := customerrepo.New(dbConn)
-	customerService := customerservice.New(customerRepo, log)
-	handler := server.NewHandler(customerService, log)
+	if cfg.Cache.Enabled {
+		customerRepo = customerrepo.Cached(customerRepo, cache.NewLru(cfg.Cache.Size, cfg.Cache.Ttl), log)
+	}
+	registry := metrics.NewRegistry()
+	db.RegisterMetrics(registry, dbConn)
+	customerService := customerservice.Instrument(customerservice.New(customerRepo, log, customerservice.Options{
+		PageSize: cfg.Pagination.PageSize,
+		Rules:    cfg.Customers.Rules(),
+	}), registry)
+	userService := userservice.New(userrepo.New(dbConn), log, userservice.Options{SessionLifetime: cfg.Auth.SessionLifetime})
+	ui.SetDir(cfg.Server.UiDir)
+	apiKeyService := apikeyservice.New(apikeyrepo.New(dbConn), log)
+	idempotencyService := idempotencyservice.New(idempotencyrepo.New(dbConn), idempotencyservice.Options{
+		Retention:   cfg.Idempotency.Retention,
+		LockTimeout: cfg.Idempotency.LockTimeout,
+	})
+	handler := server.NewHandler(customerService, userService, apiKeyService, cfg, log, server.Options{
+		Ready: func(ctx context.Context) error {
+			return db.Ready(ctx, dbConn)
+		},
+		Metrics:     registry,
+		Idempotency: idempotencyService,
+	})
 
-	// Run migrations
-	if err := db.HandleMigrations(cfg, customerService, dbConn); err != nil {
+	// schema is migrated with migrate command before start
+	if err := db.CheckMigrations(cfg); err != nil {
 		log.Fatal(err)
 	}
+	if err := db.SaveCustomerRules(ctx, dbConn, cfg.Customers.Rules()); err != nil {
+		log.Fatal(err)
+	}
+	purged := make(chan struct{})
+	go func() {
+		defer close(purged)
+		jobs.RunPurge(stop, customerService, cfg.Customers.DeletedRetention, cfg.Customers.PurgeInterval, log)
+	}()
+	sessionsPurged := make(chan struct{})
+	go func() {
+		defer close(sessionsPurged)
+		jobs.RunSessionPurge(stop, userService, sessionPurgeInterval, log)
+	}()
+	idempotencyKeysPurged := make(chan struct{})
+	go func() {
+		defer close(idempotencyKeysPurged)
+		jobs.RunIdempotencyPurge(stop, idempotencyService, idempotencyPurgeInterval, log)
+	}()
 
 	srv := &http.Server{
-		Addr:    cfg.ServerAddress,
-		Handler: handler,
+		Addr:              cfg.Server.Address,
+		Handler:           handler,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
 		BaseContext: func(l net.Listener) context.Context {
 			return ctx
 		},
 	}
-
-	go func() {
-		sig := <-ch
-		log.Infof("handle signal %v, exiting", sig)
-		srv.Shutdown(ctx)
-		cancel()
-	}()
-	log.Printf("Srn service started on port %v", cfg.ServerAddress)
-
-	log.Fatal(ctx, srv.ListenAndServe())
-
+	listener, err := net.Listen("tcp", cfg.Server.Address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Srn service started on port %v", cfg.Server.Address)
+	err = server.Serve(stop, srv, listener, cfg.Server.TlsCertFile, cfg.Server.TlsKeyFile, cfg.Server.ShutdownTimeout, log)
+	// requests left after drain deadline and running purge are cancelled before database is closed
+	cancel()
+	<-purged
+	<-sessionsPurged
+	<-idempotencyKeysPurged
+	if err != nil {
+		dbConn.Close()
+		log.Fatal(err)
+	}
+	log.Info("server stopped")
 }
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache of encoded values. Lru is in-process implementation, servers behind load balancer can share
+// entries with implementation over external cache(e.g. memcached, its add and set commands are the same).
+// Entries may disappear at any time(eviction, expiry), so cache is only a shortcut to the source of data.
+type Cache interface {
+	// value of key, found is false for missing or expired key
+	Get(ctx context.Context, key string) (value []byte, found bool, err error)
+	Set(ctx context.Context, key string, value []byte) (err error)
+	// set value only if key is missing, existing value is kept
+	Add(ctx context.Context, key string, value []byte) (err error)
+	Delete(ctx context.Context, key string) (err error)
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// In-process cache of limited count of entries, least recently used entry is evicted first.
+// Entries expire after ttl, it limits staleness of entries changed by other servers.
+type Lru struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	// most recently used entry is at the front
+	order *list.List
+	// clock of tests
+	now func() time.Time
+}
+
+func NewLru(size int, ttl time.Duration) *Lru {
+	return &Lru{size: size, ttl: ttl, entries: map[string]*list.Element{}, order: list.New(), now: time.Now}
+}
+
+func (c *Lru) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*lruEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (c *Lru) Set(ctx context.Context, key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value)
+	return nil
+}
+
+func (c *Lru) Add(ctx context.Context, key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok && c.now().Before(element.Value.(*lruEntry).expiresAt) {
+		return nil
+	}
+	c.set(key, value)
+	return nil
+}
+
+func (c *Lru) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	return nil
+}
+
+// count of entries, expired ones are counted till they are evicted or read
+func (c *Lru) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *Lru) set(key string, value []byte) {
+	expiresAt := c.now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *Lru) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry).key)
+}
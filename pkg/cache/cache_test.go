@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLruEviction(t *testing.T) {
+	c := NewLru(2, time.Minute)
+	ctx := context.Background()
+	c.Set(ctx, "a", []byte("1"))
+	c.Set(ctx, "b", []byte("2"))
+	// a becomes the most recently used one, so b is evicted
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"))
+	if _, found, _ := c.Get(ctx, "b"); found {
+		t.Error("least recently used entry must be evicted")
+	}
+	if value, found, _ := c.Get(ctx, "a"); !found || string(value) != "1" {
+		t.Error("recently used entry must be kept", found, string(value))
+	}
+	c.Add(ctx, "a", []byte("new"))
+	if value, _, _ := c.Get(ctx, "a"); string(value) != "1" {
+		t.Error("add must not replace existing entry", string(value))
+	}
+	c.Delete(ctx, "a")
+	if _, found, _ := c.Get(ctx, "a"); found || c.Len() != 1 {
+		t.Error("entry wasn't deleted", c.Len())
+	}
+}
+
+func TestLruExpiry(t *testing.T) {
+	c := NewLru(10, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+	c.Set(ctx, "a", []byte("1"))
+	now = now.Add(time.Minute)
+	if _, found, _ := c.Get(ctx, "a"); found {
+		t.Error("expired entry must not be returned")
+	}
+	c.Set(ctx, "a", []byte("1"))
+	now = now.Add(2 * time.Minute)
+	c.Add(ctx, "a", []byte("2"))
+	if value, found, _ := c.Get(ctx, "a"); !found || string(value) != "2" {
+		t.Error("add must replace expired entry", found, string(value))
+	}
+}
@@ -14,10 +14,39 @@ const (
 	KnownMessageCustomerCreated             = "Customer was successfully created."
 	KnownMessageCustomerEdited              = "Customer was successfully edited."
 	KnownMessageCustomerDeleted             = "Customer was successfully deleted."
+	KnownMessageCustomerRestored            = "Customer was successfully restored."
+	KnownMessageRestoreEmailTaken           = "Customer can't be restored, his email address is already used by another customer."
 	KnownMessageCustomerInvalidBirthDate    = "Customer birthdate must be of format yyyy-MM-dd."
-	KnownMessageCustomerInvalidAge          = "Customer age must be between 18 and 60 inclusively."
+	KnownMessageCustomerInvalidAge          = "Customer age must be between %d and %d inclusively."
+	KnownMessageCustomerInvalidGender       = "Customer gender must be one of: %s."
+	KnownMessageCustomerFieldTooLong        = "Customer %s must not be longer than %d characters."
+	KnownMessageCustomerRulesViolated       = "Customer doesn't satisfy validation rules of database, they were changed by another server."
 	KnownCustomerNotFound                   = "Give customer do not exist."
 	KnownMessageEditCustomerConflict        = "Given customer already edited, please load last data."
+	KnownMessageInvalidCursor               = "Invalid page cursor provided, please start from the first page."
+	KnownMessageInvalidCsrfToken            = "Form is expired or was sent from another site, please reload page and try again."
+	KnownMessageImportMalformedFile         = "Imported file can't be read, it must be csv or tsv file with header row."
+	KnownMessageImportMissingColumns        = "Imported file must have firstName, lastName, birthDate, gender and email columns."
+	KnownMessageImportTooManyRows           = "Imported file has too many rows, please split it into several files."
+	KnownMessageImportDuplicateEmail        = "Email address is repeated in imported file."
+	KnownMessageLoginRequired               = "Please log in to continue."
+	KnownMessageInvalidCredentials          = "Invalid login or password."
+	KnownMessageForbidden                   = "You don't have permission for this action."
+	KnownMessageInvalidUserLogin            = "Login must be 1 to 100 characters long and contain only letters, digits and . _ @ - characters."
+	KnownMessageUserLoginTaken              = "Given login is already used by another user."
+	KnownMessageInvalidPassword             = "Password must be between %d and %d bytes long."
+	KnownMessageInvalidRole                 = "Role must be one of: viewer, editor, admin."
+	KnownMessageUserNotFound                = "Given user doesn't exist."
+	KnownMessageInvalidApiKey               = "Api key is invalid, expired or revoked."
+	KnownMessageInvalidApiKeyName           = "Api key name must be 1 to 100 characters long."
+	KnownMessageInvalidApiKeyScopes         = "Api key scopes must be some of: %s."
+	KnownMessageInvalidApiKeyExpiry         = "Api key expiry must be in the future."
+	KnownMessageApiKeyNotFound              = "Given api key doesn't exist or is already revoked."
+	KnownMessageTooManyRequests             = "Too many requests, please try again later."
+	KnownMessageRequestTooLarge             = "Request body is too large."
+	KnownMessageInvalidIdempotencyKey       = "Idempotency key must be 1 to 255 printable ascii characters."
+	KnownMessageIdempotencyKeyReused        = "Idempotency key was already used for another request."
+	KnownMessageIdempotencyKeyInProgress    = "Request with the same idempotency key is still in progress, please retry later."
 )
 
 // This is custom error code
@@ -35,19 +64,38 @@ const (
 	NotFound         Code = "NotFound"
 	CustomerNotFound Code = "CustomerNotFound"
 	ResourceNotFound Code = "ResourceNotFound"
+	Unauthorized     Code = "Unauthorized"
+	Forbidden        Code = "Forbidden"
+	UserNotFound     Code = "UserNotFound"
+	ApiKeyNotFound   Code = "ApiKeyNotFound"
+	TooManyRequests  Code = "TooManyRequests"
+	RequestTooLarge  Code = "RequestTooLarge"
+	// idempotency key was used with different request
+	IdempotencyKeyReused Code = "IdempotencyKeyReused"
+	// first request with idempotency key isn't completed yet
+	IdempotencyKeyInProgress Code = "IdempotencyKeyInProgress"
 )
 
 // and reverse mapping to http status int
 var codeToStatus = map[Code]int{
-	InvalidData:      http.StatusBadRequest,
-	OverwriteData:    http.StatusConflict,
-	ServerInternal:   http.StatusInternalServerError,
-	BadRequest:       http.StatusBadRequest,
-	EmailTaken:       http.StatusBadRequest,
-	Ok:               http.StatusOK,
-	NotFound:         http.StatusNotFound,
-	Created:          http.StatusCreated,
-	CustomerNotFound: http.StatusNotFound,
+	InvalidData:              http.StatusBadRequest,
+	OverwriteData:            http.StatusConflict,
+	ServerInternal:           http.StatusInternalServerError,
+	BadRequest:               http.StatusBadRequest,
+	EmailTaken:               http.StatusBadRequest,
+	Ok:                       http.StatusOK,
+	NotFound:                 http.StatusNotFound,
+	Created:                  http.StatusCreated,
+	CustomerNotFound:         http.StatusNotFound,
+	ResourceNotFound:         http.StatusNotFound,
+	Unauthorized:             http.StatusUnauthorized,
+	Forbidden:                http.StatusForbidden,
+	UserNotFound:             http.StatusNotFound,
+	ApiKeyNotFound:           http.StatusNotFound,
+	TooManyRequests:          http.StatusTooManyRequests,
+	RequestTooLarge:          http.StatusRequestEntityTooLarge,
+	IdempotencyKeyReused:     http.StatusUnprocessableEntity,
+	IdempotencyKeyInProgress: http.StatusConflict,
 }
 
 func StatusCode(code Code) int {
@@ -85,8 +133,33 @@ func (e *errorCodeImpl) Message() string {
 	return e.MessageValue
 }
 
+// Error code that carries additional data for consumer(for example current state of resource that was edited concurrently)
+type DataErrorCode interface {
+	ErrorCode
+	Data() interface{}
+}
+
+type dataErrorCodeImpl struct {
+	errorCodeImpl
+	DataValue interface{}
+}
+
+func NewErrWithData(code Code, message string, data interface{}) DataErrorCode {
+	return &dataErrorCodeImpl{
+		errorCodeImpl: errorCodeImpl{
+			CodeValue:    code,
+			MessageValue: message,
+		},
+		DataValue: data,
+	}
+}
+func (e *dataErrorCodeImpl) Data() interface{} {
+	return e.DataValue
+}
+
 // Custom sql repository errors
 
 var NoRowsModified = errors.New("No rows modified")
 var UniqueConstraintViolation = errors.New("Unique constraint vialation")
 var BadSearchCriteria = errors.New("Bad search criteria")
+var CheckConstraintViolation = errors.New("Check constraint violation")
@@ -1,27 +1,45 @@
 package custval
 
-import "time"
+import (
+	"fmt"
+	"time"
 
-// birthdate constraints
+	"github.com/abdybaevae/customers-app/pkg/codes"
+)
+
+// default birthdate constraints
 const (
 	MinAge = 18
 	MaxAge = 60
 )
 
+// Allowed customer age range(inclusive), it's configured with customers.min_age and customers.max_age
+type AgeLimits struct {
+	Min int
+	Max int
+}
+
+var DefaultAgeLimits = AgeLimits{Min: MinAge, Max: MaxAge}
+
 // custom validation for birthdate
-func IsValidBirthDate(birthDate time.Time) bool {
+func (l AgeLimits) IsValid(birthDate time.Time) bool {
 	birthYear, birthMonth, birthDay := birthDate.Date()
 	currYear, currMonth, currDay := time.Now().Date()
 	age := currYear - birthYear
 	if currMonth < birthMonth || currMonth == birthMonth && currDay < birthDay {
 		age--
 	}
-	return age >= MinAge && age <= MaxAge
+	return age >= l.Min && age <= l.Max
 }
 
 // compute available birthdate range for current time
-func ComputeBirthDateRange() (time.Time, time.Time) {
-	minDate := time.Now().AddDate(-MaxAge, 0, 0)
-	maxDate := time.Now().AddDate(-MinAge, 0, 0)
+func (l AgeLimits) BirthDateRange() (time.Time, time.Time) {
+	minDate := time.Now().AddDate(-l.Max, 0, 0)
+	maxDate := time.Now().AddDate(-l.Min, 0, 0)
 	return minDate, maxDate
 }
+
+// message shown for customer of wrong age
+func (l AgeLimits) Message() string {
+	return fmt.Sprintf(codes.KnownMessageCustomerInvalidAge, l.Min, l.Max)
+}
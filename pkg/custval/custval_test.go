@@ -17,9 +17,23 @@ func TestIsValidBirthDate(t *testing.T) {
 		{"too young", false, time.Now().AddDate(-5, 0, 0)},
 	}
 	for _, tc := range tt {
-		got := IsValidBirthDate(tc.args)
+		got := DefaultAgeLimits.IsValid(tc.args)
 		if got != tc.want {
 			t.Error("broken test ", tc.name)
 		}
 	}
 }
+
+func TestAgeLimits(t *testing.T) {
+	limits := AgeLimits{Min: 21, Max: 30}
+	if limits.IsValid(time.Now().AddDate(-20, 0, 0)) || !limits.IsValid(time.Now().AddDate(-25, 0, 0)) || limits.IsValid(time.Now().AddDate(-31, 0, 0)) {
+		t.Error("configured limits are not used")
+	}
+	min, max := limits.BirthDateRange()
+	if min.Year() != time.Now().Year()-30 || max.Year() != time.Now().Year()-21 {
+		t.Errorf("wrong birthdate range %v - %v", min, max)
+	}
+	if limits.Message() != "Customer age must be between 21 and 30 inclusively." {
+		t.Errorf("wrong message %q", limits.Message())
+	}
+}
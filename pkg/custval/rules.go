@@ -0,0 +1,88 @@
+package custval
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+)
+
+// sizes of customers table columns, configured lengths can't be greater
+const (
+	ColumnNameLength    = 150
+	ColumnGenderLength  = 30
+	ColumnEmailLength   = 150
+	ColumnAddressLength = 300
+)
+
+// Customer validation rules, they are configured in customers section and are the same for service, ui and database
+type Rules struct {
+	Ages AgeLimits
+	// allowed gender values, "unspecified" can be one of them
+	Genders []string
+	// max count of characters in first and last names
+	MaxNameLength    int
+	MaxEmailLength   int
+	MaxAddressLength int
+}
+
+func DefaultRules() Rules {
+	return Rules{
+		Ages:             DefaultAgeLimits,
+		Genders:          []string{"female", "male", "unspecified"},
+		MaxNameLength:    100,
+		MaxEmailLength:   ColumnEmailLength,
+		MaxAddressLength: ColumnAddressLength,
+	}
+}
+
+// customer values checked by rules
+type Fields struct {
+	FirstName string
+	LastName  string
+	BirthDate time.Time
+	Gender    string
+	Email     string
+	Address   string
+}
+
+func (r Rules) IsAllowedGender(gender string) bool {
+	for _, allowed := range r.Genders {
+		if gender == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// First broken rule, error message is shown to user
+func (r Rules) Check(f Fields) error {
+	tooLong := func(name, value string, max int) error {
+		if utf8.RuneCountInString(value) > max {
+			return fmt.Errorf(codes.KnownMessageCustomerFieldTooLong, name, max)
+		}
+		return nil
+	}
+	if err := tooLong("first name", f.FirstName, r.MaxNameLength); err != nil {
+		return err
+	}
+	if err := tooLong("last name", f.LastName, r.MaxNameLength); err != nil {
+		return err
+	}
+	if err := tooLong("email", f.Email, r.MaxEmailLength); err != nil {
+		return err
+	}
+	if err := tooLong("address", f.Address, r.MaxAddressLength); err != nil {
+		return err
+	}
+	if !r.IsAllowedGender(f.Gender) {
+		return fmt.Errorf(codes.KnownMessageCustomerInvalidGender, strings.Join(r.Genders, ", "))
+	}
+	if !r.Ages.IsValid(f.BirthDate) {
+		return errors.New(r.Ages.Message())
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package custval
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRulesCheck(t *testing.T) {
+	rules := Rules{
+		Ages:             AgeLimits{Min: 18, Max: 60},
+		Genders:          []string{"female", "male", "unspecified"},
+		MaxNameLength:    5,
+		MaxEmailLength:   20,
+		MaxAddressLength: 10,
+	}
+	valid := Fields{FirstName: "Анна", LastName: "Doe", BirthDate: time.Now().AddDate(-30, 0, 0), Gender: "unspecified", Email: "anna@example.com"}
+	if err := rules.Check(valid); err != nil {
+		t.Fatal("valid customer is rejected", err)
+	}
+	tt := []struct {
+		name   string
+		change func(f *Fields)
+		want   string
+	}{
+		{"long first name", func(f *Fields) { f.FirstName = "Joanna" }, "Customer first name must not be longer than 5 characters."},
+		{"long last name", func(f *Fields) { f.LastName = "Dooooe" }, "Customer last name must not be longer than 5 characters."},
+		{"long email", func(f *Fields) { f.Email = strings.Repeat("a", 10) + "@example.com" }, "Customer email must not be longer than 20 characters."},
+		{"long address", func(f *Fields) { f.Address = "Long street 1" }, "Customer address must not be longer than 10 characters."},
+		{"unknown gender", func(f *Fields) { f.Gender = "other" }, "Customer gender must be one of: female, male, unspecified."},
+		{"too young", func(f *Fields) { f.BirthDate = time.Now().AddDate(-10, 0, 0) }, "Customer age must be between 18 and 60 inclusively."},
+	}
+	for _, tc := range tt {
+		fields := valid
+		tc.change(&fields)
+		if err := rules.Check(fields); err == nil || err.Error() != tc.want {
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("http_requests_total", "Count of requests.", "route", "code")
+	requests.Inc("/customers/{id}", "200")
+	requests.Add(2, "/", "200")
+	r.Counter("customers_created_total", "Created customers.")
+	latency := r.Histogram("http_request_duration_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	latency.Observe(0.05, `"quoted"`)
+	latency.Observe(0.5, `"quoted"`)
+	latency.Observe(3, `"quoted"`)
+	r.GaugeFunc("db_open_connections", "Open connections.", func() float64 { return 4 })
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP http_requests_total Count of requests.
+# TYPE http_requests_total counter
+http_requests_total{route="/customers/{id}",code="200"} 1
+http_requests_total{route="/",code="200"} 2
+# HELP customers_created_total Created customers.
+# TYPE customers_created_total counter
+customers_created_total 0
+# HELP http_request_duration_seconds Request latency.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{route="\"quoted\"",le="0.1"} 1
+http_request_duration_seconds_bucket{route="\"quoted\"",le="1"} 2
+http_request_duration_seconds_bucket{route="\"quoted\"",le="+Inf"} 3
+http_request_duration_seconds_sum{route="\"quoted\""} 3.55
+http_request_duration_seconds_count{route="\"quoted\""} 3
+# HELP db_open_connections Open connections.
+# TYPE db_open_connections gauge
+db_open_connections 4
+`
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected output:\n%s", got)
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong count of label values must panic")
+		}
+	}()
+	NewRegistry().Counter("c", "help", "a").Inc()
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("c_total", "help").Inc()
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") || !strings.Contains(rec.Body.String(), "c_total 1") {
+		t.Error("counter value is not written", rec.Body.String())
+	}
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Api key of machine client, key itself is shown only once when it's created
+type ApiKey struct {
+	Id        int            `db:"key_id"`
+	Name      string         `db:"key_name"`
+	Prefix    string         `db:"key_prefix"`
+	Hash      string         `db:"key_hash"`
+	Scopes    pq.StringArray `db:"key_scopes"`
+	CreatedBy string         `db:"key_created_by"`
+	CreatedAt time.Time      `db:"key_created_at"`
+	// nil if key doesn't expire
+	ExpiresAt  *time.Time `db:"key_expires_at"`
+	LastUsedAt *time.Time `db:"key_last_used_at"`
+	RevokedAt  *time.Time `db:"key_revoked_at"`
+}
+
+func (k *ApiKey) HasScope(scope Scope) bool {
+	for _, granted := range k.Scopes {
+		if Scope(granted) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// key is accepted if it isn't revoked and isn't expired at given time
+func (k *ApiKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Audit actions
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	// customer was permanently removed after retention period
+	AuditPurge = "purge"
+)
+
+// Customer audit record, it's written in the same transaction as customer change.
+type CustomerAudit struct {
+	Id         int64        `db:"audit_id"`
+	CustomerId int          `db:"customer_id"`
+	Action     string       `db:"audit_action"`
+	Changes    FieldChanges `db:"audit_changes"`
+	Actor      string       `db:"audit_actor"`
+	RequestId  string       `db:"audit_request_id"`
+	CreatedAt  time.Time    `db:"audit_created_at"`
+}
+
+// Changed customer field, before is nil for created customer and after is nil for deleted one.
+type FieldChange struct {
+	Field  string  `json:"field"`
+	Before *string `json:"before"`
+	After  *string `json:"after"`
+}
+
+// Field changes are stored as jsonb array
+type FieldChanges []FieldChange
+
+func (c FieldChanges) Value() (driver.Value, error) {
+	if c == nil {
+		c = FieldChanges{}
+	}
+	data, err := json.Marshal(c)
+	return string(data), err
+}
+
+func (c *FieldChanges) Scan(src interface{}) error {
+	switch data := src.(type) {
+	case []byte:
+		return json.Unmarshal(data, c)
+	case string:
+		return json.Unmarshal([]byte(data), c)
+	case nil:
+		*c = nil
+		return nil
+	}
+	return errors.New("unsupported type of field changes")
+}
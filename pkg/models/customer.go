@@ -21,4 +21,8 @@ type Customer struct {
 	CreatedAt time.Time `db:"customer_created_at"`
 	UpdatedAt time.Time `db:"customer_updated_at"`
 	Hash      string    `db:"customer_hash"`
+	// deleted customers are kept for retention period and can be restored, nil for active customer
+	DeletedAt *time.Time `db:"customer_deleted_at"`
+	// relevance of customer for search query, it's filled only by search lists
+	SearchRank float64 `db:"customer_search_rank"`
 }
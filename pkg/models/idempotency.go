@@ -0,0 +1,49 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Request sent with Idempotency-Key header and its response, response is nil while the first request is handled
+type IdempotentRequest struct {
+	Owner       string    `db:"idempotency_owner"`
+	Key         string    `db:"idempotency_key"`
+	Fingerprint string    `db:"request_fingerprint"`
+	Status      *int      `db:"response_status"`
+	Headers     Headers   `db:"response_headers"`
+	Body        []byte    `db:"response_body"`
+	CreatedAt   time.Time `db:"idempotency_created_at"`
+	// when the request with key was started, it's moved by retry which takes over stale request
+	LockedAt time.Time `db:"idempotency_locked_at"`
+}
+
+func (r *IdempotentRequest) IsCompleted() bool {
+	return r.Status != nil
+}
+
+// Response headers are stored as jsonb object
+type Headers map[string]string
+
+func (h Headers) Value() (driver.Value, error) {
+	if h == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(h)
+	return string(data), err
+}
+
+func (h *Headers) Scan(src interface{}) error {
+	switch data := src.(type) {
+	case []byte:
+		return json.Unmarshal(data, h)
+	case string:
+		return json.Unmarshal([]byte(data), h)
+	case nil:
+		*h = nil
+		return nil
+	}
+	return errors.New("unsupported type of headers")
+}
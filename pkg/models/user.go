@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+)
+
+// Permission to act on customers, user receives scopes of his role and api key has its own scopes
+type Scope string
+
+const (
+	// read customers, their history and export them
+	ScopeCustomersRead Scope = "customers:read"
+	// create, edit, import and restore customers
+	ScopeCustomersWrite  Scope = "customers:write"
+	ScopeCustomersDelete Scope = "customers:delete"
+	// scrape /metrics, counters of customer changes aren't public
+	ScopeMetricsRead Scope = "metrics:read"
+)
+
+var AllScopes = []Scope{ScopeCustomersRead, ScopeCustomersWrite, ScopeCustomersDelete, ScopeMetricsRead}
+
+func (s Scope) IsValid() bool {
+	for _, scope := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// User role, every next role can do everything previous one can
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+var roleScopes = map[Role][]Scope{
+	RoleViewer: {ScopeCustomersRead},
+	RoleEditor: {ScopeCustomersRead, ScopeCustomersWrite},
+	RoleAdmin:  {ScopeCustomersRead, ScopeCustomersWrite, ScopeCustomersDelete, ScopeMetricsRead},
+}
+
+func (r Role) IsValid() bool {
+	_, ok := roleScopes[r]
+	return ok
+}
+
+// role includes given scope
+func (r Role) Grants(scope Scope) bool {
+	for _, granted := range roleScopes[r] {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// User account of customer ui
+type User struct {
+	Id           int       `db:"user_id"`
+	Login        string    `db:"user_login"`
+	PasswordHash string    `db:"user_password_hash"`
+	Role         Role      `db:"user_role"`
+	CreatedAt    time.Time `db:"user_created_at"`
+}
+
+// Session of logged in user, token itself is known only to browser
+type Session struct {
+	TokenHash string    `db:"session_token_hash"`
+	UserId    int       `db:"user_id"`
+	CreatedAt time.Time `db:"session_created_at"`
+	ExpiresAt time.Time `db:"session_expires_at"`
+}
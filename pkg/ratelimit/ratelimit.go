@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Token bucket limit: bucket holds up to Burst tokens and is refilled with Rate tokens per second,
+// every request takes one token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Store keeps buckets of clients. Memory store is enough for one server, servers behind load balancer
+// can share buckets with store implemented over shared storage(e.g. redis script doing the same math).
+type Store interface {
+	// take token from bucket of key, if there is no token retryAfter tells when the next one appears
+	Take(ctx context.Context, key string, limit Limit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// limit of the last take, buckets of different limits(e.g. read and write) are kept in one map
+	limit Limit
+}
+
+// full buckets are removed this often, client without bucket starts with full one anyway
+const sweepInterval = time.Minute
+
+// In-process store of buckets
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// clock of tests
+	now func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+		s.lastSweep = now
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	b.tokens = refill(b, now)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	if limit.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64), nil
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)), nil
+}
+
+func refill(b *bucket, now time.Time) float64 {
+	return math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate)
+}
+
+// buckets which would be full now are the same as missing ones
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if refill(b, now) >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 3}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if allowed, _, _ := store.Take(ctx, "ip:1", limit); !allowed {
+			t.Fatal("burst must be allowed", i)
+		}
+	}
+	allowed, retryAfter, _ := store.Take(ctx, "ip:1", limit)
+	if allowed || retryAfter != 500*time.Millisecond {
+		t.Error("empty bucket must reject until next token", allowed, retryAfter)
+	}
+	if allowed, _, _ := store.Take(ctx, "ip:2", limit); !allowed {
+		t.Error("buckets of keys must be separate")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if allowed, _, _ := store.Take(ctx, "ip:1", limit); !allowed {
+		t.Error("bucket must be refilled with rate")
+	}
+	if allowed, _, _ := store.Take(ctx, "ip:1", limit); allowed {
+		t.Error("refilled token must be taken once")
+	}
+	now = now.Add(time.Hour)
+	store.Take(ctx, "ip:3", limit)
+	if len(store.buckets) != 1 {
+		t.Error("full buckets must be swept", len(store.buckets))
+	}
+}
+
+// every bucket is swept with its own limit, not with limit of request which triggered sweep
+func TestMemoryStoreSweepOfLimits(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	read, write := Limit{Rate: 10, Burst: 100}, Limit{Rate: 0.01, Burst: 5}
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		store.Take(ctx, "write:ip:1", write)
+	}
+	store.Take(ctx, "read:ip:1", read)
+	now = now.Add(2 * sweepInterval)
+	store.Take(ctx, "read:ip:2", read)
+	if _, ok := store.buckets["write:ip:1"]; !ok {
+		t.Error("write bucket which isn't refilled yet must not be swept by read request")
+	}
+	if _, ok := store.buckets["read:ip:1"]; ok {
+		t.Error("full read bucket must be swept")
+	}
+	if allowed, _, _ := store.Take(ctx, "write:ip:1", write); !allowed {
+		t.Error("write bucket must keep refilled token")
+	}
+	if allowed, _, _ := store.Take(ctx, "write:ip:1", write); allowed {
+		t.Error("write bucket must not be refilled with read rate")
+	}
+}
@@ -0,0 +1,72 @@
+package apikey
+
+import (
+	"context"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// Repository of api keys of machine clients.
+type ApiKeyRepo interface {
+	// insert key and fill its generated id and creation time
+	Create(ctx context.Context, key *models.ApiKey) (err error)
+	// key by hash of its value, sql.ErrNoRows if there is no such key(revoked and expired keys are returned too)
+	GetByHash(ctx context.Context, hash string) (key *models.ApiKey, err error)
+	// all keys, newest first
+	List(ctx context.Context) ([]models.ApiKey, error)
+	// remember when key was used last time
+	Touch(ctx context.Context, keyId int, usedAt time.Time) (err error)
+	// revoke active key, NoRowsModified if there is no such key or it's already revoked
+	Revoke(ctx context.Context, keyId int, revokedAt time.Time) (err error)
+}
+
+type repo struct {
+	db *sqlx.DB
+}
+
+func New(db *sqlx.DB) ApiKeyRepo {
+	return &repo{
+		db,
+	}
+}
+
+const createKeyQuery = `
+insert into api_keys (key_name, key_prefix, key_hash, key_scopes, key_created_by, key_expires_at)
+values ($1, $2, $3, $4, $5, $6) returning key_id, key_created_at
+`
+
+func (r *repo) Create(ctx context.Context, key *models.ApiKey) error {
+	return r.db.QueryRowxContext(ctx, createKeyQuery, key.Name, key.Prefix, key.Hash, key.Scopes, key.CreatedBy, key.ExpiresAt).
+		Scan(&key.Id, &key.CreatedAt)
+}
+
+func (r *repo) GetByHash(ctx context.Context, hash string) (*models.ApiKey, error) {
+	key := &models.ApiKey{}
+	err := r.db.GetContext(ctx, key, "select * from api_keys where key_hash = $1", hash)
+	return key, err
+}
+
+func (r *repo) List(ctx context.Context) ([]models.ApiKey, error) {
+	keys := []models.ApiKey{}
+	err := r.db.SelectContext(ctx, &keys, "select * from api_keys order by key_created_at desc, key_id desc")
+	return keys, err
+}
+
+func (r *repo) Touch(ctx context.Context, keyId int, usedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "update api_keys set key_last_used_at = $2 where key_id = $1", keyId, usedAt)
+	return err
+}
+
+func (r *repo) Revoke(ctx context.Context, keyId int, revokedAt time.Time) error {
+	result, err := r.db.ExecContext(ctx, "update api_keys set key_revoked_at = $2 where key_id = $1 and key_revoked_at is null", keyId, revokedAt)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil || count == 0 {
+		return codes.NoRowsModified
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package apikey
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+func conn() (*sqlx.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("en error %s was not expted ", err)
+	}
+	return sqlx.NewDb(db, "sqlmock"), mock
+}
+
+func TestCreateKey(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	key := &models.ApiKey{Name: "sync", Prefix: "cak_1234", Hash: "hash", Scopes: pq.StringArray{"customers:read"}, CreatedBy: "admin"}
+	mock.ExpectQuery("insert into api_keys").WithArgs("sync", "cak_1234", "hash", sqlmock.AnyArg(), "admin", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"key_id", "key_created_at"}).AddRow(3, time.Now()))
+	if err := repo.Create(context.Background(), key); err != nil || key.Id != 3 {
+		t.Error("key wasn't created", err, key.Id)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRevokeKey(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	now := time.Now()
+	mock.ExpectExec("update api_keys set key_revoked_at = \\$2 where key_id = \\$1 and key_revoked_at is null").WithArgs(3, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update api_keys set key_revoked_at").WithArgs(3, now).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := repo.Revoke(context.Background(), 3, now); err != nil {
+		t.Error("key wasn't revoked", err)
+	}
+	if err := repo.Revoke(context.Background(), 3, now); err != codes.NoRowsModified {
+		t.Error("revoked key must be reported", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,76 @@
+package customer
+
+import (
+	"context"
+
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/jmoiron/sqlx"
+)
+
+// Every customer change writes audit record in the same transaction, so history can't miss committed change.
+// Actor and request id are taken from context.
+const insertAuditQuery = `
+insert into customer_audit
+	(customer_id, audit_action, audit_changes, audit_actor, audit_request_id)
+values
+	($1, $2, $3, $4, $5)
+`
+
+func writeAudit(ctx context.Context, tx *sqlx.Tx, customerId int, action string, changes models.FieldChanges) error {
+	// nothing was changed, there is nothing to remember
+	if action == models.AuditUpdate && len(changes) == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, insertAuditQuery, customerId, action, changes, reqinfo.Actor(ctx), reqinfo.RequestId(ctx))
+	return err
+}
+
+const historyQuery = `
+select * from customer_audit where customer_id = $1 order by audit_created_at, audit_id
+`
+
+func (r *repo) History(ctx context.Context, customerId int) ([]models.CustomerAudit, error) {
+	history := []models.CustomerAudit{}
+	err := r.db.SelectContext(ctx, &history, historyQuery, customerId)
+	return history, err
+}
+
+// audited customer fields(db column name and formatted value)
+func auditFields(customer *models.Customer) [][2]string {
+	return [][2]string{
+		{"customer_first_name", customer.FirstName},
+		{"customer_last_name", customer.LastName},
+		{"customer_birth_date", utils.FormatBirthDate(customer.BirthDate)},
+		{"customer_gender", customer.Gender},
+		{"customer_email", customer.Email},
+		{"customer_address", customer.Address},
+	}
+}
+
+// Changed fields between two customer states, nil state means customer didn't exist(before creation or after deletion)
+func diffCustomers(before, after *models.Customer) models.FieldChanges {
+	changes := models.FieldChanges{}
+	var beforeFields, afterFields [][2]string
+	if before != nil {
+		beforeFields = auditFields(before)
+	}
+	if after != nil {
+		afterFields = auditFields(after)
+	}
+	for i := range auditFields(&models.Customer{}) {
+		change := models.FieldChange{}
+		if beforeFields != nil {
+			change.Field, change.Before = beforeFields[i][0], &beforeFields[i][1]
+		}
+		if afterFields != nil {
+			change.Field, change.After = afterFields[i][0], &afterFields[i][1]
+		}
+		if change.Before != nil && change.After != nil && *change.Before == *change.After {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
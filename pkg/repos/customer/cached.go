@@ -0,0 +1,240 @@
+package customer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/cache"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Read-through cache of customers and customer lists, other methods go directly to repository.
+//
+// Customer is versioned with its hash: "customer:<id>" keeps current hash and "customer:<id>@<hash>" keeps customer
+// of that version. Edit replaces version with the new hash, so customer of previous hash is never read again, even if
+// slow reader puts it into cache after the edit(reader adds version only if it's missing and puts customer only
+// under version it has seen). Lists and counts are keyed with generation which is replaced by every change of customers.
+// Versions and generation are changed only by changes made through this cache, so customers and lists changed by other
+// processes(servers with another cache, seed command) are stale until entries expire. Stale customer can't overwrite
+// newer one anyway, update checks its hash. Cache failures are logged and requests fall back to repository.
+type cachedRepo struct {
+	CustomerRepo
+	cache cache.Cache
+	log   *logrus.Entry
+}
+
+const (
+	listGenerationKey = "customers:generation"
+	// version of deleted customer, it never matches customer hash
+	deletedVersion = "deleted"
+)
+
+func Cached(repo CustomerRepo, c cache.Cache, log *logrus.Entry) CustomerRepo {
+	return &cachedRepo{CustomerRepo: repo, cache: c, log: log}
+}
+
+func versionKey(customerId int) string {
+	return "customer:" + strconv.Itoa(customerId)
+}
+
+func customerKey(customerId int, hash string) string {
+	return versionKey(customerId) + "@" + hash
+}
+
+func (r *cachedRepo) GetById(ctx context.Context, customerId int) (*models.Customer, error) {
+	version, found := r.get(ctx, versionKey(customerId))
+	if found {
+		customer := &models.Customer{}
+		if data, ok := r.get(ctx, customerKey(customerId, string(version))); ok && r.decode(ctx, data, customer) {
+			return customer, nil
+		}
+	}
+	customer, err := r.CustomerRepo.GetById(ctx, customerId)
+	if err != nil {
+		return customer, err
+	}
+	if !found {
+		r.add(ctx, versionKey(customerId), []byte(customer.Hash))
+	} else if string(version) != customer.Hash {
+		// edit isn't finished yet or version was changed by another server, customer is served without cache
+		return customer, nil
+	}
+	r.setJson(ctx, customerKey(customerId, customer.Hash), customer)
+	return customer, nil
+}
+
+func (r *cachedRepo) Create(ctx context.Context, customer *models.Customer) error {
+	err := r.CustomerRepo.Create(ctx, customer)
+	if err == nil {
+		r.newGeneration(ctx)
+	}
+	return err
+}
+
+func (r *cachedRepo) CreateMany(ctx context.Context, customers []*models.Customer) error {
+	err := r.CustomerRepo.CreateMany(ctx, customers)
+	if err == nil {
+		r.newGeneration(ctx)
+	}
+	return err
+}
+
+func (r *cachedRepo) Update(ctx context.Context, customer *models.Customer) error {
+	err := r.CustomerRepo.Update(ctx, customer)
+	if err == nil {
+		// repository put new hash into customer
+		r.set(ctx, versionKey(customer.Id), []byte(customer.Hash))
+		r.newGeneration(ctx)
+	} else if _, ok := err.(*ConflictError); ok {
+		// client edited stale customer, it may come from cache of another server
+		r.delete(ctx, versionKey(customer.Id))
+	}
+	return err
+}
+
+func (r *cachedRepo) DeleteById(ctx context.Context, customerId int) error {
+	err := r.CustomerRepo.DeleteById(ctx, customerId)
+	if err == nil {
+		r.set(ctx, versionKey(customerId), []byte(deletedVersion))
+		r.newGeneration(ctx)
+	}
+	return err
+}
+
+func (r *cachedRepo) Restore(ctx context.Context, customerId int) error {
+	err := r.CustomerRepo.Restore(ctx, customerId)
+	if err == nil {
+		r.delete(ctx, versionKey(customerId))
+		r.newGeneration(ctx)
+	}
+	return err
+}
+
+func (r *cachedRepo) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	count, err := r.CustomerRepo.Purge(ctx, retention)
+	if err == nil && count > 0 {
+		r.newGeneration(ctx)
+	}
+	return count, err
+}
+
+func (r *cachedRepo) QueryList(ctx context.Context, query *ListQuery) ([]models.Customer, error) {
+	customers := []models.Customer{}
+	err := r.readThrough(ctx, "list", query, &customers, func() (interface{}, error) {
+		return r.CustomerRepo.QueryList(ctx, query)
+	})
+	return customers, err
+}
+
+func (r *cachedRepo) SearchQueryList(ctx context.Context, query *ListQuery) ([]models.Customer, error) {
+	customers := []models.Customer{}
+	err := r.readThrough(ctx, "search", query, &customers, func() (interface{}, error) {
+		return r.CustomerRepo.SearchQueryList(ctx, query)
+	})
+	return customers, err
+}
+
+func (r *cachedRepo) Count(ctx context.Context, pattern string) (int, error) {
+	var count int
+	err := r.readThrough(ctx, "count", pattern, &count, func() (interface{}, error) {
+		return r.CustomerRepo.Count(ctx, pattern)
+	})
+	return count, err
+}
+
+// Result of query is read from cache into result or loaded and put into cache. Key is computed before loading,
+// so result loaded while customers are changed is put under previous generation and isn't read.
+func (r *cachedRepo) readThrough(ctx context.Context, kind string, query interface{}, result interface{}, load func() (interface{}, error)) error {
+	encodedQuery, err := json.Marshal(query)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(encodedQuery)
+	key := "customers:" + r.generation(ctx) + ":" + kind + ":" + hex.EncodeToString(hash[:])
+	if data, ok := r.get(ctx, key); ok && r.decode(ctx, data, result) {
+		return nil
+	}
+	loaded, err := load()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(loaded)
+	if err != nil {
+		return err
+	}
+	r.set(ctx, key, data)
+	return json.Unmarshal(data, result)
+}
+
+// current generation of lists, new one is started if it's missing(e.g. evicted)
+func (r *cachedRepo) generation(ctx context.Context) string {
+	if generation, ok := r.get(ctx, listGenerationKey); ok {
+		return string(generation)
+	}
+	generation := utils.GenCustomerHash()
+	r.add(ctx, listGenerationKey, []byte(generation))
+	// another server could start generation at the same time
+	if current, ok := r.get(ctx, listGenerationKey); ok {
+		return string(current)
+	}
+	return generation
+}
+
+func (r *cachedRepo) newGeneration(ctx context.Context) {
+	r.set(ctx, listGenerationKey, []byte(utils.GenCustomerHash()))
+}
+
+func (r *cachedRepo) get(ctx context.Context, key string) ([]byte, bool) {
+	value, found, err := r.cache.Get(ctx, key)
+	if err != nil {
+		r.logger(ctx).Warnf("cache get of %s failed: %v", key, err)
+		return nil, false
+	}
+	return value, found
+}
+
+func (r *cachedRepo) set(ctx context.Context, key string, value []byte) {
+	if err := r.cache.Set(ctx, key, value); err != nil {
+		r.logger(ctx).Warnf("cache set of %s failed: %v", key, err)
+	}
+}
+
+func (r *cachedRepo) setJson(ctx context.Context, key string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		r.logger(ctx).Warnf("value of %s wasn't cached: %v", key, err)
+		return
+	}
+	r.set(ctx, key, data)
+}
+
+func (r *cachedRepo) add(ctx context.Context, key string, value []byte) {
+	if err := r.cache.Add(ctx, key, value); err != nil {
+		r.logger(ctx).Warnf("cache add of %s failed: %v", key, err)
+	}
+}
+
+func (r *cachedRepo) delete(ctx context.Context, key string) {
+	if err := r.cache.Delete(ctx, key); err != nil {
+		r.logger(ctx).Warnf("cache delete of %s failed: %v", key, err)
+	}
+}
+
+func (r *cachedRepo) decode(ctx context.Context, data []byte, value interface{}) bool {
+	if err := json.Unmarshal(data, value); err != nil {
+		r.logger(ctx).Warnf("cached value is broken: %v", err)
+		return false
+	}
+	return true
+}
+
+func (r *cachedRepo) logger(ctx context.Context) *logrus.Entry {
+	return reqinfo.Logger(ctx, r.log)
+}
@@ -0,0 +1,161 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/cache"
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+// in memory repository which counts reads, hash is changed by every update like in database
+type countingRepo struct {
+	CustomerRepo
+	customers map[int]models.Customer
+	reads     int
+	// called in the middle of read, it simulates edit made while customer is read
+	duringRead func()
+}
+
+func newCountingRepo() *countingRepo {
+	return &countingRepo{customers: map[int]models.Customer{1: {Id: 1, FirstName: "John", Hash: "hash-1"}}}
+}
+
+func (r *countingRepo) GetById(ctx context.Context, customerId int) (*models.Customer, error) {
+	r.reads++
+	customer, ok := r.customers[customerId]
+	if r.duringRead != nil {
+		duringRead := r.duringRead
+		r.duringRead = nil
+		duringRead()
+	}
+	if !ok {
+		return nil, codes.NoRowsModified
+	}
+	return &customer, nil
+}
+
+func (r *countingRepo) Create(ctx context.Context, customer *models.Customer) error {
+	customer.Id = len(r.customers) + 1
+	r.customers[customer.Id] = *customer
+	return nil
+}
+
+func (r *countingRepo) Update(ctx context.Context, customer *models.Customer) error {
+	if current := r.customers[customer.Id]; current.Hash != customer.Hash {
+		return &ConflictError{Current: &current}
+	}
+	customer.Hash = customer.Hash + "+"
+	r.customers[customer.Id] = *customer
+	return nil
+}
+
+func (r *countingRepo) DeleteById(ctx context.Context, customerId int) error {
+	delete(r.customers, customerId)
+	return nil
+}
+
+func (r *countingRepo) QueryList(ctx context.Context, query *ListQuery) ([]models.Customer, error) {
+	r.reads++
+	customers := []models.Customer{}
+	for _, customer := range r.customers {
+		customers = append(customers, customer)
+	}
+	return customers, nil
+}
+
+func newCachedRepo(repo CustomerRepo) CustomerRepo {
+	return Cached(repo, cache.NewLru(100, time.Minute), logrus.NewEntry(logrus.New()))
+}
+
+func TestCachedGetById(t *testing.T) {
+	repo := newCountingRepo()
+	cached := newCachedRepo(repo)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if customer, err := cached.GetById(ctx, 1); err != nil || customer.FirstName != "John" {
+			t.Fatal("customer wasn't read", err)
+		}
+	}
+	if repo.reads != 1 {
+		t.Error("customer must be read from cache", repo.reads)
+	}
+	if err := cached.Update(ctx, &models.Customer{Id: 1, FirstName: "Jack", Hash: "hash-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if customer, _ := cached.GetById(ctx, 1); customer.FirstName != "Jack" || customer.Hash != "hash-1+" || repo.reads != 2 {
+		t.Error("edited customer must be read again", customer, repo.reads)
+	}
+	cached.DeleteById(ctx, 1)
+	if _, err := cached.GetById(ctx, 1); err != codes.NoRowsModified {
+		t.Error("deleted customer must not be served", err)
+	}
+}
+
+// customer read before edit is put into cache after it, but its hash isn't current version
+func TestCachedSlowReader(t *testing.T) {
+	repo := newCountingRepo()
+	cached := newCachedRepo(repo)
+	ctx := context.Background()
+	repo.duringRead = func() {
+		cached.Update(ctx, &models.Customer{Id: 1, FirstName: "Jack", Hash: "hash-1"})
+	}
+	if customer, _ := cached.GetById(ctx, 1); customer.FirstName != "John" {
+		t.Fatal("slow reader must receive customer it has read", customer)
+	}
+	if customer, _ := cached.GetById(ctx, 1); customer.FirstName != "Jack" {
+		t.Error("stale customer must not be served after edit", customer)
+	}
+}
+
+func TestCachedLists(t *testing.T) {
+	repo := newCountingRepo()
+	cached := newCachedRepo(repo)
+	ctx := context.Background()
+	query := &ListQuery{OrderBy: "customer_first_name", OrderByValue: "asc", Limit: 20}
+	cached.QueryList(ctx, query)
+	if customers, err := cached.QueryList(ctx, query); err != nil || len(customers) != 1 || repo.reads != 1 {
+		t.Fatal("list must be read from cache", err, len(customers), repo.reads)
+	}
+	cached.QueryList(ctx, &ListQuery{OrderBy: "customer_email", OrderByValue: "asc", Limit: 20})
+	if repo.reads != 2 {
+		t.Error("lists of different queries must be cached separately", repo.reads)
+	}
+	cached.Create(ctx, &models.Customer{FirstName: "Jane", Hash: "hash-2"})
+	if customers, _ := cached.QueryList(ctx, query); len(customers) != 2 {
+		t.Error("lists must be invalidated by created customer", len(customers))
+	}
+}
+
+// cache which is down
+type failingCache struct{}
+
+func (failingCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return nil, false, errors.New("cache is down")
+}
+func (failingCache) Set(ctx context.Context, key string, value []byte) error {
+	return errors.New("cache is down")
+}
+func (failingCache) Add(ctx context.Context, key string, value []byte) error {
+	return errors.New("cache is down")
+}
+func (failingCache) Delete(ctx context.Context, key string) error {
+	return errors.New("cache is down")
+}
+
+func TestCacheFailure(t *testing.T) {
+	repo := newCountingRepo()
+	cached := Cached(repo, failingCache{}, logrus.NewEntry(logrus.New()))
+	for i := 0; i < 2; i++ {
+		if customer, err := cached.GetById(context.Background(), 1); err != nil || customer.FirstName != "John" {
+			t.Error("customer must be read from repository", err)
+		}
+	}
+	if repo.reads != 2 {
+		t.Error("every read must go to repository", repo.reads)
+	}
+}
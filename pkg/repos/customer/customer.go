@@ -2,12 +2,14 @@ package customer
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/abdybaevae/customers-app/pkg/codes"
 	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
 	"github.com/abdybaevae/customers-app/pkg/utils"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
@@ -16,18 +18,45 @@ import (
 // Simple customer repository that works with customer entity.
 type CustomerRepo interface {
 	Create(ctx context.Context, data *models.Customer) (err error)
+	// bulk insert of customers in single transaction(none of them is stored if any fails), ids are filled
+	CreateMany(ctx context.Context, customers []*models.Customer) (err error)
+	// which of given emails are used by active customers
+	ExistingEmails(ctx context.Context, emails []string) ([]string, error)
+	// update customer if its hash wasn't changed, otherwise ConflictError with current customer state is returned.
+	// Hash of given customer is replaced with the new one.
 	Update(ctx context.Context, data *models.Customer) (err error)
+	// mark customer as deleted, it's kept till purge and can be restored
 	DeleteById(ctx context.Context, customerId int) (err error)
+	// restore deleted customer
+	Restore(ctx context.Context, customerId int) (err error)
+	// permanently remove customers deleted more than retention ago, returns count of removed customers
+	Purge(ctx context.Context, retention time.Duration) (count int64, err error)
+	// get active(not deleted) customer
 	GetById(ctx context.Context, customerId int) (customer *models.Customer, err error)
-	// query customers without search pattern
-	QueryList(ctx context.Context, offset int, orderBy string, orderByValue string, limit int) ([]models.Customer, error)
-	// query customers with search pattern
-	SearchQueryList(ctx context.Context, offset int, orderBy string, orderByValue string, limit int, pattern string) ([]models.Customer, error)
+	// query customers without search pattern(deleted customers are listed only if query asks for them)
+	QueryList(ctx context.Context, query *ListQuery) ([]models.Customer, error)
+	// query customers with search pattern, customers are ranked by relevance first
+	SearchQueryList(ctx context.Context, query *ListQuery) ([]models.Customer, error)
+	// stream all customers of list(with or without search pattern) to each function, pagination fields of query are ignored
+	Export(ctx context.Context, query *ListQuery, each func(customer *models.Customer) error) error
+	// count active customers matching search pattern(all active customers if pattern is empty)
+	Count(ctx context.Context, pattern string) (int, error)
+	// audit records of customer, oldest first
+	History(ctx context.Context, customerId int) ([]models.CustomerAudit, error)
 }
 type repo struct {
 	db *sqlx.DB
 }
 
+// Customer was edited by someone else since client received it(hash was changed)
+type ConflictError struct {
+	Current *models.Customer
+}
+
+func (e *ConflictError) Error() string {
+	return "customer was changed concurrently"
+}
+
 func New(db *sqlx.DB) CustomerRepo {
 	return &repo{
 		db,
@@ -54,11 +83,12 @@ insert into customers
 		:customer_email,
 		:customer_address,
 		:customer_hash
-	)	
+	)
+	returning customer_id
 `
 
 const getByIdQuery = `
-select * from customers where customer_id = $1
+select * from customers where customer_id = $1 and customer_deleted_at is null
 `
 
 func (r *repo) GetById(ctx context.Context, customerId int) (*models.Customer, error) {
@@ -66,11 +96,111 @@ func (r *repo) GetById(ctx context.Context, customerId int) (*models.Customer, e
 	err := r.db.GetContext(ctx, customer, getByIdQuery, customerId)
 	return customer, err
 }
+
+// run queries in transaction, it's committed only if query function succeeds
+func (r *repo) withTx(ctx context.Context, query func(tx *sqlx.Tx) error) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := query(tx); err != nil {
+		reqinfo.Logger(ctx, nil).Debugf("transaction is rolled back: %v", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		reqinfo.Logger(ctx, nil).Warnf("transaction commit failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Create inserts customer, fills its generated id and writes audit record.
 func (r *repo) Create(ctx context.Context, customer *models.Customer) error {
-	_, err := r.db.NamedExecContext(ctx, createCustomerQuery, customer)
-	// this is email duplication error code, reject customer creation
-	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
-		return codes.UniqueConstraintViolation
+	return r.withTx(ctx, func(tx *sqlx.Tx) error {
+		query, args, err := tx.BindNamed(createCustomerQuery, customer)
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRowxContext(ctx, query, args...).Scan(&customer.Id); err != nil {
+			return mapCreateErr(err)
+		}
+		return writeAudit(ctx, tx, customer.Id, models.AuditCreate, diffCustomers(nil, customer))
+	})
+}
+
+// rows of one multi row insert, postgres limits count of query parameters(65535)
+const createManyChunkSize = 1000
+
+// Customers are inserted with multi row insert statements, so there is one round trip per chunk instead of one per customer.
+func (r *repo) CreateMany(ctx context.Context, customers []*models.Customer) error {
+	return r.withTx(ctx, func(tx *sqlx.Tx) error {
+		for start := 0; start < len(customers); start += createManyChunkSize {
+			end := start + createManyChunkSize
+			if end > len(customers) {
+				end = len(customers)
+			}
+			if err := insertChunk(ctx, tx, customers[start:end]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func insertChunk(ctx context.Context, tx *sqlx.Tx, customers []*models.Customer) error {
+	args := &queryArgs{}
+	values := make([]string, 0, len(customers))
+	for _, c := range customers {
+		values = append(values, "("+strings.Join([]string{args.add(c.FirstName), args.add(c.LastName), args.add(c.BirthDate),
+			args.add(c.Gender), args.add(c.Email), args.add(c.Address), args.add(c.Hash)}, ", ")+")")
+	}
+	query := `insert into customers (customer_first_name, customer_last_name, customer_birth_date, customer_gender,
+	customer_email, customer_address, customer_hash) values ` + strings.Join(values, ", ") + " returning customer_id, customer_email"
+	// order of returned rows isn't guaranteed, ids are matched by email which is unique among active customers
+	inserted := []models.Customer{}
+	if err := tx.SelectContext(ctx, &inserted, query, *args...); err != nil {
+		return mapCreateErr(err)
+	}
+	ids := make(map[string]int, len(inserted))
+	for _, c := range inserted {
+		ids[c.Email] = c.Id
+	}
+	auditArgs := &queryArgs{}
+	auditValues := make([]string, 0, len(customers))
+	for _, c := range customers {
+		id, ok := ids[c.Email]
+		if !ok {
+			return fmt.Errorf("id of inserted customer %s wasn't returned", c.Email)
+		}
+		c.Id = id
+		auditValues = append(auditValues, "("+strings.Join([]string{auditArgs.add(c.Id), auditArgs.add(models.AuditCreate),
+			auditArgs.add(diffCustomers(nil, c)), auditArgs.add(reqinfo.Actor(ctx)), auditArgs.add(reqinfo.RequestId(ctx))}, ", ")+")")
+	}
+	_, err := tx.ExecContext(ctx, `insert into customer_audit (customer_id, audit_action, audit_changes, audit_actor, audit_request_id)
+	values `+strings.Join(auditValues, ", "), *auditArgs...)
+	return err
+}
+
+const existingEmailsQuery = `
+select customer_email from customers where customer_email = any($1) and customer_deleted_at is null
+`
+
+func (r *repo) ExistingEmails(ctx context.Context, emails []string) ([]string, error) {
+	existing := []string{}
+	err := r.db.SelectContext(ctx, &existing, existingEmailsQuery, pq.Array(emails))
+	return existing, err
+}
+
+// this is email duplication error code, reject customer creation. Check violation is raised by customer rules trigger.
+func mapCreateErr(err error) error {
+	if err, ok := err.(*pq.Error); ok {
+		switch err.Code {
+		case "23505":
+			return codes.UniqueConstraintViolation
+		case "23514":
+			return codes.CheckConstraintViolation
+		}
 	}
 	return err
 }
@@ -84,99 +214,110 @@ set
 	customer_gender = $3,
 	customer_address = $4,
 	customer_birth_date = $5,
-	customer_hash = $6
+	customer_email = $6,
+	customer_hash = $7
 where 
-	customer_id = $7 
+	customer_id = $8 
 	and 
-	customer_hash = $8
+	customer_hash = $9
+	and
+	customer_deleted_at is null
+`
+
+// current customer state is locked till the end of transaction, it's used as before values of audit record
+const getByIdForUpdateQuery = `
+select * from customers where customer_id = $1 and customer_deleted_at is null for update
 `
 
 func (r *repo) Update(ctx context.Context, customer *models.Customer) error {
-	res, err := r.db.ExecContext(ctx, updateCustomerQuery, customer.FirstName, customer.LastName, customer.Gender,
-		customer.Address, customer.BirthDate, utils.GenCustomerHash(), customer.Id, customer.Hash)
-	if err != nil {
-		return err
-	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-	// tricky part, it means either customer do not exist with given id or hash already changed(but customer exists), it's possible to create solution to differentiate
-	// this situations, but let's think this isn't our case and just return user already chaged error.
-	if count == 0 {
-		return codes.NoRowsModified
+	newHash := utils.GenCustomerHash()
+	err := r.withTx(ctx, func(tx *sqlx.Tx) error {
+		before := &models.Customer{}
+		if err := tx.GetContext(ctx, before, getByIdForUpdateQuery, customer.Id); err != nil {
+			if err == sql.ErrNoRows {
+				return codes.NoRowsModified
+			}
+			return err
+		}
+		// customer row is locked, so hash can't be changed till the end of transaction
+		if before.Hash != customer.Hash {
+			return &ConflictError{Current: before}
+		}
+		if _, err := tx.ExecContext(ctx, updateCustomerQuery, customer.FirstName, customer.LastName, customer.Gender,
+			customer.Address, customer.BirthDate, customer.Email, newHash, customer.Id, customer.Hash); err != nil {
+			// new email can be used by another customer
+			return mapCreateErr(err)
+		}
+		after := *before
+		after.FirstName, after.LastName, after.Gender = customer.FirstName, customer.LastName, customer.Gender
+		after.Address, after.BirthDate, after.Email = customer.Address, customer.BirthDate, customer.Email
+		return writeAudit(ctx, tx, customer.Id, models.AuditUpdate, diffCustomers(before, &after))
+	})
+	if err == nil {
+		customer.Hash = newHash
 	}
-	return nil
-
+	return err
 }
 
 const deleteCustomerQuery = `
-delete 
-from customers
+update customers
+set customer_deleted_at = now()
 where customer_id = $1
 `
 
 func (r *repo) DeleteById(ctx context.Context, customerId int) error {
-	res, err := r.db.ExecContext(ctx, deleteCustomerQuery, customerId)
-	if err != nil {
-		return err
-	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if count == 0 {
-		return codes.NoRowsModified
-	}
-	return nil
+	return r.withTx(ctx, func(tx *sqlx.Tx) error {
+		before := &models.Customer{}
+		if err := tx.GetContext(ctx, before, getByIdForUpdateQuery, customerId); err != nil {
+			if err == sql.ErrNoRows {
+				return codes.NoRowsModified
+			}
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, deleteCustomerQuery, customerId); err != nil {
+			return err
+		}
+		return writeAudit(ctx, tx, customerId, models.AuditDelete, diffCustomers(before, nil))
+	})
 }
 
-const queryListQuery = `
-	SELECT * FROM customers 
-	ORDER BY %s %s
-	OFFSET $1
-	LIMIT $2
+const restoreCustomerQuery = `
+update customers
+set customer_deleted_at = null
+where customer_id = $1 and customer_deleted_at is not null
+returning *
 `
 
-func (r *repo) QueryList(ctx context.Context, offset int, orderBy string, orderByValue string, limit int) ([]models.Customer, error) {
-	customers := []models.Customer{}
-	actQuery := fmt.Sprintf(queryListQuery, orderBy, orderByValue)
-	err := r.db.SelectContext(ctx, &customers, actQuery, offset, limit)
-	return customers, err
-}
-
-// It makes sense that if you wanna find customer by firstName or lastName you exactly start searching by typing starts of their names
-// It's means there are less chances when someone wants to search customers by providing substring that is not prefix of firstname or lastname
-// that is why I decided to choose simple postgresql search by pattern function
-// Generally we can use full text search by postgres(tsvector?) or elasticsearch to make efficient search mechanism or create custom solution...
-// but for current implementation I decided to use simple search approach.
-// Suppose user want to search customers by providing string with spaces. It seems reasonably that user want to search customers(or list of customers in this criteria)
-// So, let's split this string and search for all occurences.
-func (r *repo) SearchQueryList(ctx context.Context, offset int, orderBy string, orderByValue string, limit int, pattern string) ([]models.Customer, error) {
-	if orderByValue == "" {
-		return nil, codes.BadSearchCriteria
-	}
-	tokens := strings.Split(pattern, " ")
-	var sb strings.Builder
-	sb.WriteString("select * from customers where ")
-	tokenLen := len(tokens)
-	args := []interface{}{}
-	seenTokens := map[string]bool{}
-	for i, token := range tokens {
-		token = strings.ToLower(strings.TrimSpace(token))
-		if token == "" || seenTokens[token] {
-			continue
-		}
-		if i != 0 {
-			sb.WriteString(" or ")
+func (r *repo) Restore(ctx context.Context, customerId int) error {
+	return r.withTx(ctx, func(tx *sqlx.Tx) error {
+		restored := &models.Customer{}
+		if err := tx.GetContext(ctx, restored, restoreCustomerQuery, customerId); err != nil {
+			if err == sql.ErrNoRows {
+				return codes.NoRowsModified
+			}
+			// email of deleted customer was already taken by another one
+			return mapCreateErr(err)
 		}
-		sb.WriteString("customer_first_name ilike '%' || $" + strconv.Itoa(2*i+1) + " || '%' or ")
-		sb.WriteString("customer_last_name ilike '%' || $" + strconv.Itoa(2*i+2) + " || '%'")
-		args = append(args, token, token)
+		return writeAudit(ctx, tx, customerId, models.AuditRestore, diffCustomers(nil, restored))
+	})
+}
+
+// customers are removed and audited with single statement, time is compared on db side(deletion time was set there too)
+const purgeCustomersQuery = `
+with purged as (
+	delete from customers
+	where customer_deleted_at < now() - make_interval(secs => $1)
+	returning customer_id
+)
+insert into customer_audit
+	(customer_id, audit_action, audit_changes, audit_actor, audit_request_id)
+select customer_id, $2, '[]', $3, $4 from purged
+`
+
+func (r *repo) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	res, err := r.db.ExecContext(ctx, purgeCustomersQuery, retention.Seconds(), models.AuditPurge, reqinfo.Actor(ctx), reqinfo.RequestId(ctx))
+	if err != nil {
+		return 0, err
 	}
-	sb.WriteString(" order by " + orderBy + " " + orderByValue + " offset $" + strconv.Itoa(2*tokenLen+1) + " limit $" + strconv.Itoa(2*tokenLen+2))
-	args = append(args, offset, limit)
-	ret := []models.Customer{}
-	err := r.db.SelectContext(ctx, &ret, sb.String(), args...)
-	return ret, err
+	return res.RowsAffected()
 }
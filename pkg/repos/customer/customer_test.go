@@ -2,12 +2,16 @@ package customer
 
 import (
 	"context"
+	"database/sql/driver"
 	"log"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/abdybaevae/customers-app/pkg/codes"
 	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 
 	"testing"
 )
@@ -26,17 +30,28 @@ func TestCreateCustomer(t *testing.T) {
 	db, mock := conn()
 	repo := New(db)
 	defer db.Close()
-	mock.ExpectExec("insert into customers").WithArgs(newCustomer.FirstName,
+	mock.ExpectBegin()
+	mock.ExpectQuery("insert into customers").WithArgs(newCustomer.FirstName,
 		newCustomer.LastName,
 		newCustomer.BirthDate,
 		newCustomer.Gender,
 		newCustomer.Email,
 		newCustomer.Address,
 		newCustomer.Hash,
-	).WillReturnResult(sqlmock.NewResult(1, 1))
-	if err := repo.Create(context.Background(), newCustomer); err != nil {
+	).WillReturnRows(sqlmock.NewRows([]string{"customer_id"}).AddRow(1))
+	mock.ExpectExec("insert into customer_audit").WithArgs(1, models.AuditCreate, sqlmock.AnyArg(), "admin", "request-1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	ctx := reqinfo.WithRequestId(reqinfo.WithActor(context.Background(), "admin"), "request-1")
+	if err := repo.Create(ctx, newCustomer); err != nil {
 		t.Error("error while inserting", err)
 	}
+	if newCustomer.Id != 1 {
+		t.Error("customer id wasn't filled after insert", newCustomer.Id)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
 }
 
 // func TestQueryList(t *testing.T) {
@@ -51,6 +66,187 @@ func TestCreateCustomer(t *testing.T) {
 // 	}
 // 	t.Log(res)
 // }
+func TestBuildSearchQuery(t *testing.T) {
+	type test struct {
+		name    string
+		pattern string
+		tsQuery string
+		text    string
+	}
+	tt := []test{
+		{"single word", "John", "john:*", "john"},
+		{"several words with duplicates", " john  Smith john", "john:* | smith:*", "john smith"},
+		{"tsquery operators are dropped", "jo&hn | !smith:*", "jo:* | hn:* | smith:*", "jo hn smith"},
+		{"email is split to words", "john@doe.com", "john:* | doe:* | com:*", "john doe com"},
+		{"nothing to search", " !! ", "", ""},
+	}
+	for _, tc := range tt {
+		tsQuery, text := buildSearchQuery(tc.pattern)
+		if tsQuery != tc.tsQuery || text != tc.text {
+			t.Errorf("%s: got %q %q", tc.name, tsQuery, text)
+		}
+	}
+}
+
+func TestSearchQueryList(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	mock.ExpectBegin()
+	mock.ExpectExec("set_config").WithArgs(searchWordSimilarityThreshold).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("select \\*, .* as customer_search_rank from customers").WithArgs("john:* | smith:*", "john smith", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"customer_id", "customer_first_name"}).AddRow(1, "John"))
+	mock.ExpectCommit()
+	res, err := repo.SearchQueryList(context.Background(), &ListQuery{
+		OrderBy:      "customer_first_name",
+		OrderByValue: "asc",
+		Limit:        10,
+		Search:       "john smith",
+	})
+	if err != nil {
+		t.Fatal("error while search", err)
+	}
+	if len(res) != 1 || res[0].FirstName != "John" {
+		t.Error("unexpected search result", res)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueryListKeyset(t *testing.T) {
+	type test struct {
+		name  string
+		query *ListQuery
+		sql   string
+		args  []driver.Value
+	}
+	after := &Keyset{Value: "John", Id: 5}
+	tt := []test{
+		{"first page", &ListQuery{OrderBy: "customer_first_name", OrderByValue: "asc", Limit: 21},
+			"select * from customers where customer_deleted_at is null order by customer_first_name asc, customer_id asc limit $1", []driver.Value{21}},
+		{"next page", &ListQuery{OrderBy: "customer_first_name", OrderByValue: "asc", Limit: 21, After: after},
+			"select * from customers where customer_deleted_at is null and (customer_first_name, customer_id) > ($1::text, $2) order by customer_first_name asc, customer_id asc limit $3",
+			[]driver.Value{"John", 5, 21}},
+		{"previous page of descending list", &ListQuery{OrderBy: "customer_first_name", OrderByValue: "desc", Limit: 21, After: after, Backward: true},
+			"select * from customers where customer_deleted_at is null and (customer_first_name, customer_id) > ($1::text, $2) order by customer_first_name asc, customer_id asc limit $3",
+			[]driver.Value{"John", 5, 21}},
+		{"nullable column", &ListQuery{OrderBy: "customer_address", OrderByValue: "desc", Limit: 21, After: &Keyset{Value: "", Id: 5}},
+			"select * from customers where customer_deleted_at is null and (coalesce(customer_address, ''), customer_id) < ($1::text, $2) order by coalesce(customer_address, '') desc, customer_id desc limit $3",
+			[]driver.Value{"", 5, 21}},
+		{"deleted customers", &ListQuery{OrderBy: "customer_email", OrderByValue: "asc", Limit: 21, Deleted: true},
+			"select * from customers where customer_deleted_at is not null order by customer_email asc, customer_id asc limit $1", []driver.Value{21}},
+	}
+	for _, tc := range tt {
+		db, mock := connExact()
+		repo := New(db)
+		mock.ExpectQuery(tc.sql).WithArgs(tc.args...).WillReturnRows(sqlmock.NewRows([]string{"customer_id"}))
+		if _, err := repo.QueryList(context.Background(), tc.query); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		db.Close()
+	}
+}
+
+func TestQueryListRejectsUnknownOrder(t *testing.T) {
+	db, _ := conn()
+	defer db.Close()
+	repo := New(db)
+	_, err := repo.QueryList(context.Background(), &ListQuery{OrderBy: "customer_id; drop table customers", OrderByValue: "asc", Limit: 1})
+	if err != codes.BadSearchCriteria {
+		t.Error("unknown order column must be rejected", err)
+	}
+}
+
+func TestKeysetOf(t *testing.T) {
+	customer := &models.Customer{Id: 3, FirstName: "John", BirthDate: time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC), SearchRank: 0.5}
+	keyset, err := KeysetOf(customer, "customer_birth_date")
+	if err != nil || keyset.Value != "1990-01-02" || keyset.Id != 3 || keyset.Rank != 0.5 {
+		t.Error("unexpected keyset", keyset, err)
+	}
+}
+
+func TestDiffCustomers(t *testing.T) {
+	before := *newCustomer
+	after := *newCustomer
+	after.Address = "New address"
+	changes := diffCustomers(&before, &after)
+	if len(changes) != 1 || changes[0].Field != "customer_address" || *changes[0].Before != "Address" || *changes[0].After != "New address" {
+		t.Error("unexpected update changes", changes)
+	}
+	changes = diffCustomers(nil, &after)
+	if len(changes) != 6 || changes[0].Before != nil || *changes[0].After != "FirstName" {
+		t.Error("created customer must have all fields", changes)
+	}
+	changes = diffCustomers(&before, nil)
+	if len(changes) != 6 || changes[0].After != nil || *changes[0].Before != "FirstName" {
+		t.Error("deleted customer must have all fields", changes)
+	}
+}
+
+func TestUpdateNotFoundAndConflict(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	mock.ExpectBegin()
+	mock.ExpectQuery("for update").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"customer_id"}))
+	mock.ExpectRollback()
+	if err := repo.Update(context.Background(), &models.Customer{Id: 1, Hash: "old"}); err != codes.NoRowsModified {
+		t.Error("missing customer must be reported as not modified", err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery("for update").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"customer_id", "customer_first_name", "customer_hash"}).AddRow(2, "John", "new"))
+	mock.ExpectRollback()
+	err := repo.Update(context.Background(), &models.Customer{Id: 2, Hash: "old"})
+	conflict, ok := err.(*ConflictError)
+	if !ok || conflict.Current.FirstName != "John" || conflict.Current.Hash != "new" {
+		t.Error("changed hash must be reported as conflict with current customer", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRestoreTakenEmail(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	mock.ExpectBegin()
+	mock.ExpectQuery("update customers set customer_deleted_at = null").WithArgs(1).WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+	if err := repo.Restore(context.Background(), 1); err != codes.UniqueConstraintViolation {
+		t.Error("restore must report taken email", err)
+	}
+}
+
+func TestDeleteWritesAudit(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	mock.ExpectBegin()
+	mock.ExpectQuery("select \\* from customers where customer_id = \\$1 and customer_deleted_at is null for update").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"customer_id", "customer_first_name"}).AddRow(1, "John"))
+	mock.ExpectExec("update customers set customer_deleted_at = now\\(\\)").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into customer_audit").WithArgs(1, models.AuditDelete, sqlmock.AnyArg(), reqinfo.SystemActor, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	if err := repo.DeleteById(context.Background(), 1); err != nil {
+		t.Error("error while deleting", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func connExact() (*sqlx.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatalf("en error %s was not expted ", err)
+	}
+	return sqlx.NewDb(db, "sqlmock"), mock
+}
+
 func conn() (*sqlx.DB, sqlmock.Sqlmock) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -58,3 +254,64 @@ func conn() (*sqlx.DB, sqlmock.Sqlmock) {
 	}
 	return sqlx.NewDb(db, "sqlmock"), mock
 }
+
+func TestCreateMany(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	first, second := *newCustomer, *newCustomer
+	second.Email = "taken@gmail.com"
+	mock.ExpectBegin()
+	mock.ExpectQuery("insert into customers .* values \\(\\$1, .*\\$7\\), \\(\\$8, .*\\$14\\) returning customer_id, customer_email").
+		WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+	if err := repo.CreateMany(context.Background(), []*models.Customer{&first, &second}); err != codes.UniqueConstraintViolation {
+		t.Error("taken email must be reported", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery("insert into customers").WithArgs(first.FirstName, first.LastName, first.BirthDate, first.Gender, first.Email,
+		first.Address, first.Hash, second.FirstName, second.LastName, second.BirthDate, second.Gender, second.Email, second.Address, second.Hash).
+		WillReturnRows(sqlmock.NewRows([]string{"customer_id", "customer_email"}).AddRow(6, second.Email).AddRow(5, first.Email))
+	mock.ExpectExec("insert into customer_audit").
+		WithArgs(5, models.AuditCreate, sqlmock.AnyArg(), reqinfo.SystemActor, "", 6, models.AuditCreate, sqlmock.AnyArg(), reqinfo.SystemActor, "").
+		WillReturnResult(sqlmock.NewResult(2, 2))
+	mock.ExpectCommit()
+	if err := repo.CreateMany(context.Background(), []*models.Customer{&first, &second}); err != nil || first.Id != 5 || second.Id != 6 {
+		t.Error("ids weren't filled", first.Id, second.Id, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportFetchesFromCursor(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	batch := sqlmock.NewRows([]string{"customer_id"})
+	for id := 1; id <= exportFetchSize; id++ {
+		batch.AddRow(id)
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec("set_config").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("declare customers_export no scroll cursor for select \\* from customers where customer_deleted_at is null order by customer_email desc, customer_id desc$").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("fetch 500 from customers_export").WillReturnRows(batch)
+	mock.ExpectQuery("fetch 500 from customers_export").WillReturnRows(sqlmock.NewRows([]string{"customer_id"}).AddRow(501))
+	mock.ExpectCommit()
+	count := 0
+	query := &ListQuery{OrderBy: "customer_email", OrderByValue: "desc", Limit: 21, After: &Keyset{Id: 1}}
+	err := repo.Export(context.Background(), query, func(customer *models.Customer) error {
+		count++
+		return nil
+	})
+	if err != nil || count != exportFetchSize+1 {
+		t.Error("all customers must be exported", count, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,282 @@
+package customer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/jmoiron/sqlx"
+)
+
+// Customer lists are paginated with keyset(seek) method instead of offset. Page is requested relatively to boundary
+// customer of neighbour page: its order by column value and id(id makes order unique). Database seeks directly to the boundary,
+// so deep pages are as cheap as the first one and rows don't shift between pages when customers are added or deleted.
+type ListQuery struct {
+	OrderBy      string
+	OrderByValue string
+	// zero means all customers, it's used only by export
+	Limit int
+	// search pattern, used only by SearchQueryList
+	Search string
+	// boundary customer of neighbour page, nil for the first page
+	After *Keyset
+	// read page before boundary customer(previous page), customers are returned in reversed order
+	Backward bool
+	// list only deleted customers instead of active ones
+	Deleted bool
+}
+
+// Position of customer in ordered list
+type Keyset struct {
+	Value string
+	Id    int
+	// search relevance, used only by search lists
+	Rank float64
+}
+
+type orderColumn struct {
+	// sql expression used in order by and keyset condition
+	expr string
+	// type of keyset value, it's passed to db as text
+	cast  string
+	value func(customer *models.Customer) string
+}
+
+// Only these columns can be used for ordering, it's also protection from injection into order by clause
+var orderColumns = map[string]orderColumn{
+	"customer_first_name": {"customer_first_name", "text", func(c *models.Customer) string { return c.FirstName }},
+	"customer_last_name":  {"customer_last_name", "text", func(c *models.Customer) string { return c.LastName }},
+	"customer_email":      {"customer_email", "text", func(c *models.Customer) string { return c.Email }},
+	// address is nullable, but null can't be compared in keyset condition
+	"customer_address":    {"coalesce(customer_address, '')", "text", func(c *models.Customer) string { return c.Address }},
+	"customer_birth_date": {"customer_birth_date", "date", func(c *models.Customer) string { return utils.FormatBirthDate(c.BirthDate) }},
+}
+
+// Keyset of given customer in list ordered by orderBy column
+func KeysetOf(customer *models.Customer, orderBy string) (*Keyset, error) {
+	column, ok := orderColumns[orderBy]
+	if !ok {
+		return nil, codes.BadSearchCriteria
+	}
+	return &Keyset{Value: column.value(customer), Id: customer.Id, Rank: customer.SearchRank}, nil
+}
+
+// positional query arguments, placeholder numbers always match count of added arguments
+type queryArgs []interface{}
+
+func (a *queryArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// sql order and keyset comparison operator for current page
+func pageDirection(orderByValue string, backward bool) (string, string) {
+	asc := orderByValue == "asc"
+	if backward {
+		asc = !asc
+	}
+	if asc {
+		return "asc", ">"
+	}
+	return "desc", "<"
+}
+
+// deleted customers are never mixed with active ones
+func (q *ListQuery) deletedCondition() string {
+	if q.Deleted {
+		return "customer_deleted_at is not null"
+	}
+	return "customer_deleted_at is null"
+}
+
+func (q *ListQuery) column() (orderColumn, error) {
+	column, ok := orderColumns[q.OrderBy]
+	if !ok || (q.OrderByValue != "asc" && q.OrderByValue != "desc") {
+		return column, codes.BadSearchCriteria
+	}
+	return column, nil
+}
+
+func (q *ListQuery) limitClause(args *queryArgs) string {
+	if q.Limit == 0 {
+		return ""
+	}
+	return " limit " + args.add(q.Limit)
+}
+
+// sql of list without search pattern
+func (q *ListQuery) listSql() (string, []interface{}, error) {
+	column, err := q.column()
+	if err != nil {
+		return "", nil, err
+	}
+	order, op := pageDirection(q.OrderByValue, q.Backward)
+	args := &queryArgs{}
+	var sb strings.Builder
+	sb.WriteString("select * from customers where " + q.deletedCondition())
+	if q.After != nil {
+		fmt.Fprintf(&sb, " and (%s, customer_id) %s (%s::%s, %s)", column.expr, op, args.add(q.After.Value), column.cast, args.add(q.After.Id))
+	}
+	fmt.Fprintf(&sb, " order by %s %s, customer_id %s%s", column.expr, order, order, q.limitClause(args))
+	return sb.String(), *args, nil
+}
+
+func (r *repo) QueryList(ctx context.Context, q *ListQuery) ([]models.Customer, error) {
+	query, args, err := q.listSql()
+	if err != nil {
+		return nil, err
+	}
+	customers := []models.Customer{}
+	err = r.db.SelectContext(ctx, &customers, query, args...)
+	return customers, err
+}
+
+// Search document includes name, email and address. Expression must be the same as in search indexes migration
+// (000002_add_customers_search_index), otherwise postgres won't use indexes.
+const customerSearchDocument = `(customer_first_name || ' ' || customer_last_name || ' ' || customer_email || ' ' || coalesce(customer_address, ''))`
+
+// Customer matches search either by full text search with prefix matching(so user can type only start of the name)
+// or by trigram word similarity(so small typos are tolerated).
+func searchCondition(tsQueryArg, textArg string) string {
+	return "to_tsvector('simple', " + customerSearchDocument + ") @@ to_tsquery('simple', " + tsQueryArg + ")" +
+		" or " + textArg + " <% " + customerSearchDocument
+}
+
+// Relevance of both search methods, it has real type in postgres
+func searchRank(tsQueryArg, textArg string) string {
+	return "ts_rank(to_tsvector('simple', " + customerSearchDocument + "), to_tsquery('simple', " + tsQueryArg + "))" +
+		" + word_similarity(" + textArg + ", " + customerSearchDocument + ")"
+}
+
+// default pg_trgm threshold(0.6) is too strict for typos in short names
+const searchWordSimilarityThreshold = "0.3"
+
+// Results are ranked by relevance, given order is used only for equally relevant customers.
+// So keyset of search list includes relevance too.
+func (r *repo) SearchQueryList(ctx context.Context, q *ListQuery) ([]models.Customer, error) {
+	query, args, err := q.searchSql()
+	if err != nil {
+		return nil, err
+	}
+	customers := []models.Customer{}
+	err = r.inSearchTx(ctx, func(tx *sqlx.Tx) error {
+		return tx.SelectContext(ctx, &customers, query, args...)
+	})
+	return customers, err
+}
+
+// sql of list with search pattern, it falls back to list without search if pattern has no words
+func (q *ListQuery) searchSql() (string, []interface{}, error) {
+	column, err := q.column()
+	if err != nil {
+		return "", nil, err
+	}
+	tsQuery, text := buildSearchQuery(q.Search)
+	if text == "" {
+		return q.listSql()
+	}
+	order, op := pageDirection(q.OrderByValue, q.Backward)
+	rankOrder, rankOp := pageDirection("desc", q.Backward)
+	args := &queryArgs{}
+	tsQueryArg, textArg := args.add(tsQuery), args.add(text)
+	rank := searchRank(tsQueryArg, textArg)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "select *, %s as customer_search_rank from customers where %s and (%s)", rank, q.deletedCondition(), searchCondition(tsQueryArg, textArg))
+	if q.After != nil {
+		rankArg := args.add(q.After.Rank)
+		fmt.Fprintf(&sb, " and (%s %s %s::real or %s = %s::real and (%s, customer_id) %s (%s::%s, %s))",
+			rank, rankOp, rankArg, rank, rankArg, column.expr, op, args.add(q.After.Value), column.cast, args.add(q.After.Id))
+	}
+	fmt.Fprintf(&sb, " order by customer_search_rank %s, %s %s, customer_id %s%s", rankOrder, column.expr, order, order, q.limitClause(args))
+	return sb.String(), *args, nil
+}
+
+// customers are fetched from server side cursor by batches of this size
+const exportFetchSize = 500
+
+// Export reads whole list(search pattern is used if it's given, limit and keyset are ignored) through server side cursor,
+// so only one batch of customers is kept in memory. Customers are passed to each one by one, export stops on its error.
+func (r *repo) Export(ctx context.Context, q *ListQuery, each func(customer *models.Customer) error) error {
+	all := *q
+	all.Limit, all.After, all.Backward = 0, nil, false
+	query, args, err := all.searchSql()
+	if err != nil {
+		return err
+	}
+	return r.inSearchTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, "declare customers_export no scroll cursor for "+query, args...); err != nil {
+			return err
+		}
+		for {
+			customers := []models.Customer{}
+			if err := tx.SelectContext(ctx, &customers, "fetch "+strconv.Itoa(exportFetchSize)+" from customers_export"); err != nil {
+				return err
+			}
+			for i := range customers {
+				if err := each(&customers[i]); err != nil {
+					return err
+				}
+			}
+			if len(customers) < exportFetchSize {
+				return nil
+			}
+		}
+	})
+}
+
+func (r *repo) Count(ctx context.Context, pattern string) (int, error) {
+	var count int
+	tsQuery, text := buildSearchQuery(pattern)
+	if text == "" {
+		err := r.db.GetContext(ctx, &count, "select count(*) from customers where customer_deleted_at is null")
+		return count, err
+	}
+	err := r.inSearchTx(ctx, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, &count, "select count(*) from customers where customer_deleted_at is null and ("+searchCondition("$1", "$2")+")", tsQuery, text)
+	})
+	return count, err
+}
+
+// run search queries in read only transaction with lowered similarity threshold(it's set only for current transaction),
+// export cursor lives in this transaction too
+func (r *repo) inSearchTx(ctx context.Context, query func(tx *sqlx.Tx) error) error {
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "select set_config('pg_trgm.word_similarity_threshold', $1, true)", searchWordSimilarityThreshold); err != nil {
+		return err
+	}
+	if err := query(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Split search pattern to words(only letters and digits are kept, so user input can't inject tsquery operators).
+// Returns prefix tsquery where any word can match(relevance ranking puts customers matching all words first)
+// and normalized text for trigram similarity.
+func buildSearchQuery(pattern string) (string, string) {
+	words := strings.FieldsFunc(strings.ToLower(pattern), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	seen := map[string]bool{}
+	tsTerms := []string{}
+	textWords := []string{}
+	for _, word := range words {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		tsTerms = append(tsTerms, word+":*")
+		textWords = append(textWords, word)
+	}
+	return strings.Join(tsTerms, " | "), strings.Join(textWords, " ")
+}
@@ -0,0 +1,118 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// Repository of requests sent with Idempotency-Key header and their responses. Ages of requests are compared with
+// now() of database, timestamps are stored without time zone in zone of database session.
+type IdempotencyRepo interface {
+	// insert request without response, false is returned if owner already used the key
+	Reserve(ctx context.Context, request *models.IdempotentRequest) (reserved bool, err error)
+	// request of owner with given key, sql.ErrNoRows if key isn't used
+	Get(ctx context.Context, owner, key string) (request *models.IdempotentRequest, err error)
+	// lock request which isn't completed and was locked longer than timeout ago, false is returned if it's completed
+	// or locked by another request since then
+	Relock(ctx context.Context, owner, key string, timeout time.Duration) (locked bool, err error)
+	// store response of reserved request
+	Complete(ctx context.Context, request *models.IdempotentRequest) (err error)
+	Delete(ctx context.Context, owner, key string) (err error)
+	// remove request of owner with given key if it was created longer than retention ago, false is returned if it's newer
+	DeleteExpired(ctx context.Context, owner, key string, retention time.Duration) (deleted bool, err error)
+	// remove requests created longer than retention ago, returns count of removed requests
+	DeleteOlderThan(ctx context.Context, retention time.Duration) (count int64, err error)
+}
+
+type repo struct {
+	db *sqlx.DB
+}
+
+func New(db *sqlx.DB) IdempotencyRepo {
+	return &repo{
+		db,
+	}
+}
+
+const reserveQuery = `
+insert into idempotency_keys (idempotency_owner, idempotency_key, request_fingerprint) values ($1, $2, $3)
+on conflict (idempotency_owner, idempotency_key) do nothing
+returning idempotency_created_at, idempotency_locked_at
+`
+
+func (r *repo) Reserve(ctx context.Context, request *models.IdempotentRequest) (bool, error) {
+	rows, err := r.db.QueryxContext(ctx, reserveQuery, request.Owner, request.Key, request.Fingerprint)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+	return true, rows.Scan(&request.CreatedAt, &request.LockedAt)
+}
+
+const getQuery = `
+select * from idempotency_keys where idempotency_owner = $1 and idempotency_key = $2
+`
+
+func (r *repo) Get(ctx context.Context, owner, key string) (*models.IdempotentRequest, error) {
+	request := &models.IdempotentRequest{}
+	err := r.db.GetContext(ctx, request, getQuery, owner, key)
+	return request, err
+}
+
+const relockQuery = `
+update idempotency_keys set idempotency_locked_at = now()
+where idempotency_owner = $1 and idempotency_key = $2 and response_status is null
+	and idempotency_locked_at < now() - make_interval(secs => $3)
+`
+
+func (r *repo) Relock(ctx context.Context, owner, key string, timeout time.Duration) (bool, error) {
+	return r.changed(r.db.ExecContext(ctx, relockQuery, owner, key, timeout.Seconds()))
+}
+
+const completeQuery = `
+update idempotency_keys set response_status = $3, response_headers = $4, response_body = $5
+where idempotency_owner = $1 and idempotency_key = $2
+`
+
+func (r *repo) Complete(ctx context.Context, request *models.IdempotentRequest) error {
+	_, err := r.db.ExecContext(ctx, completeQuery, request.Owner, request.Key, request.Status, request.Headers, request.Body)
+	return err
+}
+
+func (r *repo) Delete(ctx context.Context, owner, key string) error {
+	_, err := r.db.ExecContext(ctx, "delete from idempotency_keys where idempotency_owner = $1 and idempotency_key = $2", owner, key)
+	return err
+}
+
+const deleteExpiredQuery = `
+delete from idempotency_keys
+where idempotency_owner = $1 and idempotency_key = $2 and idempotency_created_at < now() - make_interval(secs => $3)
+`
+
+func (r *repo) DeleteExpired(ctx context.Context, owner, key string, retention time.Duration) (bool, error) {
+	return r.changed(r.db.ExecContext(ctx, deleteExpiredQuery, owner, key, retention.Seconds()))
+}
+
+func (r *repo) DeleteOlderThan(ctx context.Context, retention time.Duration) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "delete from idempotency_keys where idempotency_created_at < now() - make_interval(secs => $1)", retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// statement changed some row
+func (r *repo) changed(result sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
@@ -0,0 +1,95 @@
+package idempotency
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/jmoiron/sqlx"
+)
+
+func conn() (*sqlx.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("en error %s was not expted ", err)
+	}
+	return sqlx.NewDb(db, "sqlmock"), mock
+}
+
+func TestReserve(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	request := &models.IdempotentRequest{Owner: "admin", Key: "retry-1", Fingerprint: "fingerprint"}
+	mock.ExpectQuery("insert into idempotency_keys .* on conflict \\(idempotency_owner, idempotency_key\\) do nothing").
+		WithArgs("admin", "retry-1", "fingerprint").WillReturnRows(sqlmock.NewRows([]string{"idempotency_created_at", "idempotency_locked_at"}).AddRow(time.Now(), time.Now()))
+	mock.ExpectQuery("insert into idempotency_keys").WithArgs("admin", "retry-1", "fingerprint").
+		WillReturnRows(sqlmock.NewRows([]string{"idempotency_created_at", "idempotency_locked_at"}))
+	if reserved, err := repo.Reserve(context.Background(), request); err != nil || !reserved || request.LockedAt.IsZero() {
+		t.Error("key wasn't reserved", reserved, err)
+	}
+	if reserved, err := repo.Reserve(context.Background(), request); err != nil || reserved {
+		t.Error("used key must not be reserved again", reserved, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetCompleted(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	mock.ExpectQuery("select \\* from idempotency_keys where idempotency_owner = \\$1 and idempotency_key = \\$2").WithArgs("admin", "retry-1").
+		WillReturnRows(sqlmock.NewRows([]string{"idempotency_owner", "idempotency_key", "request_fingerprint", "response_status",
+			"response_headers", "response_body", "idempotency_created_at", "idempotency_locked_at"}).
+			AddRow("admin", "retry-1", "fingerprint", 201, []byte(`{"Location":"/api/v1/customers/1"}`), []byte(`{}`), time.Now(), time.Now()))
+	request, err := repo.Get(context.Background(), "admin", "retry-1")
+	if err != nil || !request.IsCompleted() || *request.Status != 201 || request.Headers["Location"] != "/api/v1/customers/1" {
+		t.Error("stored response wasn't read", err, request)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRelock(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	// age is computed by database, timestamps are stored in its time zone
+	mock.ExpectExec("update idempotency_keys set idempotency_locked_at = now\\(\\) .* response_status is null\\s+and idempotency_locked_at < now\\(\\) - make_interval\\(secs => \\$3\\)").
+		WithArgs("admin", "retry-1", 60.0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("update idempotency_keys").WithArgs("admin", "retry-1", 60.0).WillReturnResult(sqlmock.NewResult(0, 0))
+	if locked, err := repo.Relock(context.Background(), "admin", "retry-1", time.Minute); err != nil || !locked {
+		t.Error("stale request wasn't locked", locked, err)
+	}
+	if locked, err := repo.Relock(context.Background(), "admin", "retry-1", time.Minute); err != nil || locked {
+		t.Error("request locked by another retry must not be locked again", locked, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	mock.ExpectExec("delete from idempotency_keys\\s+where idempotency_owner = \\$1 and idempotency_key = \\$2 and idempotency_created_at < now\\(\\) - make_interval\\(secs => \\$3\\)").
+		WithArgs("admin", "retry-1", 3600.0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("delete from idempotency_keys where idempotency_created_at < now\\(\\) - make_interval\\(secs => \\$1\\)").
+		WithArgs(3600.0).WillReturnResult(sqlmock.NewResult(0, 3))
+	if deleted, err := repo.DeleteExpired(context.Background(), "admin", "retry-1", time.Hour); err != nil || !deleted {
+		t.Error("expired key wasn't deleted", deleted, err)
+	}
+	if count, err := repo.DeleteOlderThan(context.Background(), time.Hour); err != nil || count != 3 {
+		t.Error("expired keys weren't purged", count, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,121 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Repository of user accounts and their sessions.
+type UserRepo interface {
+	// insert user and fill its generated id, UniqueConstraintViolation is returned for taken login
+	Create(ctx context.Context, user *models.User) (err error)
+	// user by login(case insensitive), sql.ErrNoRows if there is no such user
+	GetByLogin(ctx context.Context, login string) (user *models.User, err error)
+	// change password hash and role of user, NoRowsModified if there is no such user
+	Update(ctx context.Context, user *models.User) (err error)
+	// all users ordered by login
+	List(ctx context.Context) ([]models.User, error)
+	CreateSession(ctx context.Context, session *models.Session) (err error)
+	// owner of session which isn't expired at given time, sql.ErrNoRows otherwise
+	GetSessionUser(ctx context.Context, tokenHash string, now time.Time) (user *models.User, err error)
+	DeleteSession(ctx context.Context, tokenHash string) (err error)
+	// sessions of user are removed when password or role is changed
+	DeleteUserSessions(ctx context.Context, userId int) (err error)
+	// remove sessions expired before given time, returns count of removed sessions
+	DeleteExpiredSessions(ctx context.Context, now time.Time) (count int64, err error)
+}
+
+type repo struct {
+	db *sqlx.DB
+}
+
+func New(db *sqlx.DB) UserRepo {
+	return &repo{
+		db,
+	}
+}
+
+const createUserQuery = `
+insert into users (user_login, user_password_hash, user_role) values ($1, $2, $3) returning user_id, user_created_at
+`
+
+func (r *repo) Create(ctx context.Context, user *models.User) error {
+	err := r.db.QueryRowxContext(ctx, createUserQuery, user.Login, user.PasswordHash, user.Role).Scan(&user.Id, &user.CreatedAt)
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+		return codes.UniqueConstraintViolation
+	}
+	return err
+}
+
+const getByLoginQuery = `
+select * from users where lower(user_login) = lower($1)
+`
+
+func (r *repo) GetByLogin(ctx context.Context, login string) (*models.User, error) {
+	user := &models.User{}
+	err := r.db.GetContext(ctx, user, getByLoginQuery, login)
+	return user, err
+}
+
+const updateUserQuery = `
+update users set user_password_hash = $2, user_role = $3 where user_id = $1
+`
+
+func (r *repo) Update(ctx context.Context, user *models.User) error {
+	result, err := r.db.ExecContext(ctx, updateUserQuery, user.Id, user.PasswordHash, user.Role)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil || count == 0 {
+		return codes.NoRowsModified
+	}
+	return nil
+}
+
+func (r *repo) List(ctx context.Context) ([]models.User, error) {
+	users := []models.User{}
+	err := r.db.SelectContext(ctx, &users, "select * from users order by user_login")
+	return users, err
+}
+
+const createSessionQuery = `
+insert into user_sessions (session_token_hash, user_id, session_expires_at) values ($1, $2, $3) returning session_created_at
+`
+
+func (r *repo) CreateSession(ctx context.Context, session *models.Session) error {
+	return r.db.QueryRowxContext(ctx, createSessionQuery, session.TokenHash, session.UserId, session.ExpiresAt).Scan(&session.CreatedAt)
+}
+
+const getSessionUserQuery = `
+select u.* from user_sessions s join users u on u.user_id = s.user_id
+where s.session_token_hash = $1 and s.session_expires_at > $2
+`
+
+func (r *repo) GetSessionUser(ctx context.Context, tokenHash string, now time.Time) (*models.User, error) {
+	user := &models.User{}
+	err := r.db.GetContext(ctx, user, getSessionUserQuery, tokenHash, now)
+	return user, err
+}
+
+func (r *repo) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := r.db.ExecContext(ctx, "delete from user_sessions where session_token_hash = $1", tokenHash)
+	return err
+}
+
+func (r *repo) DeleteUserSessions(ctx context.Context, userId int) error {
+	_, err := r.db.ExecContext(ctx, "delete from user_sessions where user_id = $1", userId)
+	return err
+}
+
+func (r *repo) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "delete from user_sessions where session_expires_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
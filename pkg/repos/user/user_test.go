@@ -0,0 +1,59 @@
+package user
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+func conn() (*sqlx.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("en error %s was not expted ", err)
+	}
+	return sqlx.NewDb(db, "sqlmock"), mock
+}
+
+func TestCreateUser(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	user := &models.User{Login: "admin", PasswordHash: "hash", Role: models.RoleAdmin}
+	mock.ExpectQuery("insert into users").WithArgs("admin", "hash", models.RoleAdmin).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "user_created_at"}).AddRow(1, time.Now()))
+	mock.ExpectQuery("insert into users").WithArgs("admin", "hash", models.RoleAdmin).
+		WillReturnError(&pq.Error{Code: "23505"})
+	if err := repo.Create(context.Background(), user); err != nil || user.Id != 1 {
+		t.Error("user wasn't created", err, user.Id)
+	}
+	if err := repo.Create(context.Background(), user); err != codes.UniqueConstraintViolation {
+		t.Error("taken login must be reported", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetSessionUser(t *testing.T) {
+	db, mock := conn()
+	defer db.Close()
+	repo := New(db)
+	now := time.Now()
+	mock.ExpectQuery("select u.\\* from user_sessions s join users u .* s.session_expires_at > \\$2").WithArgs("token-hash", now).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "user_login", "user_password_hash", "user_role", "user_created_at"}).
+			AddRow(1, "editor", "hash", "editor", now))
+	user, err := repo.GetSessionUser(context.Background(), "token-hash", now)
+	if err != nil || user.Login != "editor" || user.Role != models.RoleEditor {
+		t.Error("session user wasn't read", err, user)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,57 @@
+package reqinfo
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Request information that is passed through context to services and repositories(for example to write audit records).
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	requestIdKey
+	loggerKey
+)
+
+// actor used when request wasn't made on behalf of anybody(migrations, background jobs)
+const SystemActor = "system"
+
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// who performs current request
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey, requestId)
+}
+
+// id of current request, empty if there is no request
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey).(string)
+	return requestId
+}
+
+// logger of request has request id field, so every line can be found by id from response header or access log
+func WithLogger(ctx context.Context, log *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey, log)
+}
+
+// Logger of current request, fallback is used outside of requests(nil means standard logger)
+func Logger(ctx context.Context, fallback *logrus.Entry) *logrus.Entry {
+	if log, ok := ctx.Value(loggerKey).(*logrus.Entry); ok {
+		return log
+	}
+	if fallback == nil {
+		fallback = logrus.NewEntry(logrus.StandardLogger())
+	}
+	return fallback.WithContext(ctx)
+}
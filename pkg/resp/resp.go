@@ -2,9 +2,9 @@ package resp
 
 import (
 	"encoding/json"
+	"html/template"
 	"net/http"
 	"sync"
-	"text/template"
 
 	"github.com/abdybaevae/customers-app/pkg/codes"
 	"github.com/abdybaevae/customers-app/pkg/utils"
@@ -38,8 +38,9 @@ type jsonResponseFactoryImpl struct {
 	log *logrus.Logger
 }
 type htmlResponseFactoryImpl struct {
-	log       *logrus.Logger
-	templates *template.Template
+	log           *logrus.Logger
+	templates     *template.Template
+	templatesOnce sync.Once
 }
 
 var jsonRsOnce sync.Once
@@ -62,8 +63,7 @@ var htmlRsFactoryInstance ResponseFactory
 func GetHtmlResponseFactory() ResponseFactory {
 	htmlRsOnce.Do(func() {
 		htmlRsFactoryInstance = &htmlResponseFactoryImpl{
-			log:       logrus.New(),
-			templates: utils.LoadTemplates(),
+			log: logrus.New(),
 		}
 	})
 	return htmlRsFactoryInstance
@@ -71,22 +71,35 @@ func GetHtmlResponseFactory() ResponseFactory {
 
 // Default Message template data
 type rsMessage struct {
-	Message   string `json:"message"`
-	Code      string `json:"code"`
-	IsSuccess bool   `json:"isSuccess"`
+	Message   string      `json:"message"`
+	Code      string      `json:"code"`
+	IsSuccess bool        `json:"isSuccess"`
+	Data      interface{} `json:"data,omitempty"`
 }
 
-func (o *jsonResponseFactoryImpl) CodeMessage(rw http.ResponseWriter, code codes.Code, message string) {
+// respond to client with given data encoded as json(used by api for successful answers)
+func Json(rw http.ResponseWriter, status int, data interface{}) {
 	rw.Header().Set("Content-Type", "application/json")
-	res := &rsMessage{
-		Code:    string(code),
-		Message: message,
-	}
-	rw.WriteHeader(codes.StatusCode(code))
-	json.NewEncoder(rw).Encode(res)
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(data)
+}
+
+func (o *jsonResponseFactoryImpl) CodeMessage(rw http.ResponseWriter, code codes.Code, message string) {
+	status := codes.StatusCode(code)
+	Json(rw, status, &rsMessage{
+		Code:      string(code),
+		Message:   message,
+		IsSuccess: status >= 200 && status <= 299,
+	})
 }
 func (o *jsonResponseFactoryImpl) Error(rw http.ResponseWriter, err error) {
-	if errCode, ok := err.(codes.ErrorCode); ok {
+	if errCode, ok := err.(codes.DataErrorCode); ok {
+		Json(rw, codes.StatusCode(errCode.Code()), &rsMessage{
+			Code:    string(errCode.Code()),
+			Message: errCode.Message(),
+			Data:    errCode.Data(),
+		})
+	} else if errCode, ok := err.(codes.ErrorCode); ok {
 		o.CodeMessage(rw, errCode.Code(), errCode.Message())
 	} else {
 		// print warning message for unhandled message
@@ -95,7 +108,11 @@ func (o *jsonResponseFactoryImpl) Error(rw http.ResponseWriter, err error) {
 	}
 }
 func (h *htmlResponseFactoryImpl) CodeMessage(rw http.ResponseWriter, code codes.Code, message string) {
-	rw.Header().Set("Content-Type", "text/html")
+	// templates are loaded on first message, so factory can be referenced before ui files are available
+	h.templatesOnce.Do(func() {
+		h.templates = utils.LoadTemplates()
+	})
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
 	status := codes.StatusCode(code)
 	rw.WriteHeader(status)
 	h.templates.ExecuteTemplate(rw, "message", &rsMessage{
@@ -0,0 +1,135 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	apikeyrepo "github.com/abdybaevae/customers-app/pkg/repos/apikey"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// keys start with this marker, so leaked key is easy to recognize(e.g. by secret scanners)
+const KeyMarker = "cak_"
+
+// marker and first characters of random part identify key in lists and logs
+const prefixLength = len(KeyMarker) + 8
+
+// last used time is written at most once per this period, so every request doesn't write to database
+const lastUsedPrecision = time.Minute
+
+// Api keys of machine clients. Key is random token shown only on creation, database keeps its sha256.
+type ApiKeyService interface {
+	// create key with given scopes, zero lifetime means key doesn't expire
+	Create(ctx context.Context, name string, scopes []models.Scope, lifetime time.Duration) (key string, apiKey *models.ApiKey, err error)
+	// active key of given value, codes.Unauthorized if key is unknown, expired or revoked
+	Authenticate(ctx context.Context, key string) (apiKey *models.ApiKey, err error)
+	Revoke(ctx context.Context, keyId int) (err error)
+	List(ctx context.Context) (keys []models.ApiKey, err error)
+}
+
+type service struct {
+	apiKeyRepo apikeyrepo.ApiKeyRepo
+	log        *logrus.Entry
+}
+
+func New(apiKeyRepo apikeyrepo.ApiKeyRepo, log *logrus.Entry) ApiKeyService {
+	return &service{apiKeyRepo: apiKeyRepo, log: log}
+}
+
+func (s *service) Create(ctx context.Context, name string, scopes []models.Scope, lifetime time.Duration) (string, *models.ApiKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return "", nil, codes.NewErr(codes.InvalidData, codes.KnownMessageInvalidApiKeyName)
+	}
+	if lifetime < 0 {
+		return "", nil, codes.NewErr(codes.InvalidData, codes.KnownMessageInvalidApiKeyExpiry)
+	}
+	scopeValues := pq.StringArray{}
+	for _, scope := range scopes {
+		if !scope.IsValid() {
+			return "", nil, invalidScopes()
+		}
+		scopeValues = append(scopeValues, string(scope))
+	}
+	if len(scopeValues) == 0 {
+		return "", nil, invalidScopes()
+	}
+	token, err := utils.SecretToken()
+	if err != nil {
+		return "", nil, err
+	}
+	key := KeyMarker + token
+	apiKey := &models.ApiKey{
+		Name:      name,
+		Prefix:    key[:prefixLength],
+		Hash:      utils.HashToken(key),
+		Scopes:    scopeValues,
+		CreatedBy: reqinfo.Actor(ctx),
+	}
+	if lifetime > 0 {
+		expiresAt := time.Now().Add(lifetime)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := s.apiKeyRepo.Create(ctx, apiKey); err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+func invalidScopes() error {
+	all := []string{}
+	for _, scope := range models.AllScopes {
+		all = append(all, string(scope))
+	}
+	return codes.NewErr(codes.InvalidData, fmt.Sprintf(codes.KnownMessageInvalidApiKeyScopes, strings.Join(all, ", ")))
+}
+
+func (s *service) Authenticate(ctx context.Context, key string) (*models.ApiKey, error) {
+	invalidKey := codes.NewErr(codes.Unauthorized, codes.KnownMessageInvalidApiKey)
+	if !strings.HasPrefix(key, KeyMarker) {
+		return nil, invalidKey
+	}
+	apiKey, err := s.apiKeyRepo.GetByHash(ctx, utils.HashToken(key))
+	if err == sql.ErrNoRows {
+		return nil, invalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !apiKey.IsActive(now) {
+		reqinfo.Logger(ctx, s.log).Warnf("inactive api key %s(%s) was used", apiKey.Prefix, apiKey.Name)
+		return nil, invalidKey
+	}
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedPrecision {
+		// request isn't rejected because of statistics
+		if err := s.apiKeyRepo.Touch(ctx, apiKey.Id, now); err != nil {
+			reqinfo.Logger(ctx, s.log).Warnf("last use of api key %s wasn't saved: %v", apiKey.Prefix, err)
+		} else {
+			apiKey.LastUsedAt = &now
+		}
+	}
+	return apiKey, nil
+}
+
+func (s *service) Revoke(ctx context.Context, keyId int) error {
+	if err := s.apiKeyRepo.Revoke(ctx, keyId, time.Now()); err != nil {
+		if err == codes.NoRowsModified {
+			return codes.NewErr(codes.ApiKeyNotFound, codes.KnownMessageApiKeyNotFound)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *service) List(ctx context.Context) ([]models.ApiKey, error) {
+	return s.apiKeyRepo.List(ctx)
+}
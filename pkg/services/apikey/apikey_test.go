@@ -0,0 +1,130 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	apikeyrepo "github.com/abdybaevae/customers-app/pkg/repos/apikey"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/sirupsen/logrus"
+)
+
+// in memory repository of keys, it counts last used updates
+type stubRepo struct {
+	apikeyrepo.ApiKeyRepo
+	keys    []*models.ApiKey
+	touches int
+}
+
+func (r *stubRepo) Create(ctx context.Context, key *models.ApiKey) error {
+	key.Id = len(r.keys) + 1
+	r.keys = append(r.keys, key)
+	return nil
+}
+
+func (r *stubRepo) GetByHash(ctx context.Context, hash string) (*models.ApiKey, error) {
+	for _, key := range r.keys {
+		if key.Hash == hash {
+			copy := *key
+			return &copy, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *stubRepo) Touch(ctx context.Context, keyId int, usedAt time.Time) error {
+	r.touches++
+	r.keys[keyId-1].LastUsedAt = &usedAt
+	return nil
+}
+
+func (r *stubRepo) Revoke(ctx context.Context, keyId int, revokedAt time.Time) error {
+	if keyId > len(r.keys) || r.keys[keyId-1].RevokedAt != nil {
+		return codes.NoRowsModified
+	}
+	r.keys[keyId-1].RevokedAt = &revokedAt
+	return nil
+}
+
+func errCode(err error) codes.Code {
+	if errCode, ok := err.(codes.ErrorCode); ok {
+		return errCode.Code()
+	}
+	return ""
+}
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	repo := &stubRepo{}
+	service := New(repo, logrus.NewEntry(logrus.New()))
+	ctx := reqinfo.WithActor(context.Background(), "admin")
+	key, apiKey, err := service.Create(ctx, "nightly sync", []models.Scope{models.ScopeCustomersRead}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, KeyMarker) || !strings.HasPrefix(key, apiKey.Prefix) || strings.Contains(apiKey.Hash, key) {
+		t.Error("key must start with marker and prefix and be stored as hash", key, apiKey.Prefix)
+	}
+	if apiKey.CreatedBy != "admin" || apiKey.ExpiresAt != nil {
+		t.Error("unexpected key", apiKey.CreatedBy, apiKey.ExpiresAt)
+	}
+	authenticated, err := service.Authenticate(ctx, key)
+	if err != nil || !authenticated.HasScope(models.ScopeCustomersRead) || authenticated.HasScope(models.ScopeCustomersWrite) {
+		t.Fatal("key must be authenticated with its scopes", err)
+	}
+	service.Authenticate(ctx, key)
+	if repo.touches != 1 || repo.keys[0].LastUsedAt == nil {
+		t.Error("last use must be saved once per minute", repo.touches)
+	}
+	for _, invalid := range []string{"", "cak_unknown", key[len(KeyMarker):]} {
+		if _, err := service.Authenticate(ctx, invalid); errCode(err) != codes.Unauthorized {
+			t.Error("unknown key must be rejected", invalid, err)
+		}
+	}
+	if err := service.Revoke(ctx, apiKey.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Authenticate(ctx, key); errCode(err) != codes.Unauthorized {
+		t.Error("revoked key must be rejected", err)
+	}
+	if err := service.Revoke(ctx, apiKey.Id); errCode(err) != codes.ApiKeyNotFound {
+		t.Error("key can't be revoked twice", err)
+	}
+}
+
+func TestExpiredKey(t *testing.T) {
+	repo := &stubRepo{}
+	service := New(repo, logrus.NewEntry(logrus.New()))
+	key, _, err := service.Create(context.Background(), "temporary", []models.Scope{models.ScopeCustomersWrite}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-time.Second)
+	repo.keys[0].ExpiresAt = &expired
+	if _, err := service.Authenticate(context.Background(), key); errCode(err) != codes.Unauthorized {
+		t.Error("expired key must be rejected", err)
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	service := New(&stubRepo{}, logrus.NewEntry(logrus.New()))
+	tt := []struct {
+		name     string
+		scopes   []models.Scope
+		lifetime time.Duration
+	}{
+		{" ", []models.Scope{models.ScopeCustomersRead}, 0},
+		{"sync", nil, 0},
+		{"sync", []models.Scope{"customers:admin"}, 0},
+		{"sync", []models.Scope{models.ScopeCustomersRead}, -time.Hour},
+	}
+	for _, tc := range tt {
+		if _, _, err := service.Create(context.Background(), tc.name, tc.scopes, tc.lifetime); errCode(err) != codes.InvalidData {
+			t.Error("invalid key must be rejected", tc.name, tc.scopes, err)
+		}
+	}
+}
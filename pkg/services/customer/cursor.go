@@ -0,0 +1,71 @@
+package customer
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	customerrepo "github.com/abdybaevae/customers-app/pkg/repos/customer"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+)
+
+// Page cursor is opaque for clients: it's base64 encoded boundary customer of neighbour page.
+// Order, search(its hash) and deleted flag of list are stored too, so cursor can't be applied to another list.
+type cursor struct {
+	OrderBy      string  `json:"o"`
+	OrderByValue string  `json:"d"`
+	Search       string  `json:"s,omitempty"`
+	Deleted      bool    `json:"x,omitempty"`
+	Value        string  `json:"v"`
+	Id           int     `json:"i"`
+	Rank         float64 `json:"r,omitempty"`
+	Backward     bool    `json:"b,omitempty"`
+}
+
+var errInvalidCursor = codes.NewErr(codes.InvalidData, codes.KnownMessageInvalidCursor)
+
+func encodeCursor(c *cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, errInvalidCursor
+	}
+	return c, nil
+}
+
+// search value isn't put into cursor as is, it only has to match
+func searchHash(search string) string {
+	if search == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(search))
+	return base64.RawURLEncoding.EncodeToString(hash[:8])
+}
+
+// cursor was made for list of given arguments
+func (c *cursor) matches(args *dto.ListCustomersArguments) bool {
+	return c.OrderBy == args.OrderBy && c.OrderByValue == args.OrderByValue &&
+		c.Search == searchHash(args.SearchValue) && c.Deleted == args.Deleted
+}
+
+func newCursor(keyset *customerrepo.Keyset, args *dto.ListCustomersArguments, backward bool) string {
+	return encodeCursor(&cursor{
+		OrderBy:      args.OrderBy,
+		OrderByValue: args.OrderByValue,
+		Search:       searchHash(args.SearchValue),
+		Deleted:      args.Deleted,
+		Value:        keyset.Value,
+		Id:           keyset.Id,
+		Rank:         keyset.Rank,
+		Backward:     backward,
+	})
+}
@@ -3,6 +3,8 @@ package customer
 import (
 	"context"
 	"database/sql"
+	"strings"
+	"time"
 
 	"github.com/abdybaevae/customers-app/pkg/utils"
 
@@ -10,27 +12,41 @@ import (
 	"github.com/abdybaevae/customers-app/pkg/custval"
 	"github.com/abdybaevae/customers-app/pkg/models"
 	customerrepo "github.com/abdybaevae/customers-app/pkg/repos/customer"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
 	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/sirupsen/logrus"
 )
 
+// default size of customers list page
 const CustomersPerPage = 20
 
 // Customer service interface, it can do below things. As data come to untrusted resources it will be better to validate
 // data inside given service.
 type CustomerService interface {
-	// Create customer
-	Create(ctx context.Context, customer *dto.CreateCustomerArguments) (err error)
-	// Delete customer by id
+	// Create customer and return id of created one
+	Create(ctx context.Context, customer *dto.CreateCustomerArguments) (customerId int, err error)
+	// Delete customer by id, deleted customer is kept for retention period and can be restored
 	DeleteById(ctx context.Context, customerId int) (err error)
-	// update customer(arguments includes hash, which can handle properly overriding values)
+	// Restore deleted customer
+	Restore(ctx context.Context, customerId int) (err error)
+	// Permanently remove customers deleted more than retention ago, returns count of removed customers
+	Purge(ctx context.Context, retention time.Duration) (count int64, err error)
+	// update customer(arguments includes hash, which can handle properly overriding values).
+	// If customer was edited concurrently changes of different fields are merged(original values are required),
+	// otherwise codes.DataErrorCode with *dto.UpdateConflict is returned
 	Update(ctx context.Context, args *dto.UpdateCustomerArguments) (err error)
-	// query customers list(sorting by customer fields + search on firstName and lastName)
+	// query customers list(sorting by customer fields + relevance search on name, email and address)
 	QueryList(ctx context.Context, args *dto.ListCustomersArguments) (result *dto.ListCustomersResult, err error)
+	// stream all customers of list(the same search and order as QueryList) to each function, export stops on its error
+	Export(ctx context.Context, args *dto.ExportCustomersArguments, each func(item *dto.ListCustomerResultItem) error) (err error)
 	// get detailed information by customer id(including hash)
 	GetById(ctx context.Context, customerId int) (customer *models.Customer, err error)
+	// changes history of customer(who, when and what changed), oldest first
+	History(ctx context.Context, customerId int) (history []models.CustomerAudit, err error)
+	// validate imported customers and store them(unless it's dry run), invalid rows are reported in result
+	Import(ctx context.Context, args *dto.ImportCustomersArguments) (result *dto.ImportCustomersResult, err error)
 }
 
 // Following documentation, it will be better to have single instance of validation that caches struct info
@@ -40,20 +56,38 @@ var validate = validator.New()
 type service struct {
 	customerRepo customerrepo.CustomerRepo
 	log          *logrus.Entry
+	pageSize     int
+	rules        custval.Rules
+}
+
+// Configurable service rules, zero values are replaced with defaults
+type Options struct {
+	PageSize int
+	// default rules are used if no gender is allowed
+	Rules custval.Rules
 }
 
 // Main constructor for service, which applies customer repository as function arguments(di)
-func New(customerRepo customerrepo.CustomerRepo, log *logrus.Entry) CustomerService {
+func New(customerRepo customerrepo.CustomerRepo, log *logrus.Entry, opts Options) CustomerService {
+	if opts.PageSize <= 0 {
+		opts.PageSize = CustomersPerPage
+	}
+	if len(opts.Rules.Genders) == 0 {
+		opts.Rules = custval.DefaultRules()
+	}
 	return &service{customerRepo: customerRepo,
-		log: log,
+		log:      log,
+		pageSize: opts.PageSize,
+		rules:    opts.Rules,
 	}
 }
-func (s *service) Create(ctx context.Context, customer *dto.CreateCustomerArguments) error {
+func (s *service) Create(ctx context.Context, customer *dto.CreateCustomerArguments) (int, error) {
+	customer.Email = normalizeEmail(customer.Email)
 	if err := validate.Struct(customer); err != nil {
-		return codes.NewErr(codes.InvalidData, err.Error())
+		return 0, codes.NewErr(codes.InvalidData, err.Error())
 	}
-	if !custval.IsValidBirthDate(customer.BirthDate) {
-		return codes.NewErr(codes.InvalidData, codes.KnownMessageCustomerInvalidAge)
+	if err := s.rules.Check(itemFields(&customer.CustomerItem)); err != nil {
+		return 0, codes.NewErr(codes.InvalidData, err.Error())
 	}
 	customerEntity := &models.Customer{
 		FirstName: customer.FirstName,
@@ -64,23 +98,79 @@ func (s *service) Create(ctx context.Context, customer *dto.CreateCustomerArgume
 		Address:   customer.Address,
 		Hash:      utils.GenCustomerHash(),
 	}
-	return s.customerRepo.Create(ctx, customerEntity)
+	if err := s.customerRepo.Create(ctx, customerEntity); err != nil {
+		if err == codes.CheckConstraintViolation {
+			return 0, codes.NewErr(codes.InvalidData, codes.KnownMessageCustomerRulesViolated)
+		}
+		return 0, err
+	}
+	return customerEntity.Id, nil
 }
 func (s *service) DeleteById(ctx context.Context, customerId int) error {
 	if err := s.customerRepo.DeleteById(ctx, customerId); err != nil {
-		if err == sql.ErrNoRows {
+		if err == sql.ErrNoRows || err == codes.NoRowsModified {
 			return codes.NewErr(codes.CustomerNotFound, codes.KnownCustomerNotFound)
 		}
 		return err
 	}
 	return nil
 }
+func (s *service) Restore(ctx context.Context, customerId int) error {
+	err := s.customerRepo.Restore(ctx, customerId)
+	switch err {
+	case nil:
+		return nil
+	case codes.NoRowsModified:
+		return codes.NewErr(codes.CustomerNotFound, codes.KnownCustomerNotFound)
+	case codes.UniqueConstraintViolation:
+		return codes.NewErr(codes.EmailTaken, codes.KnownMessageRestoreEmailTaken)
+	}
+	return err
+}
+
+// logger of current request(with request id), service logger is used outside of requests
+func (s *service) logger(ctx context.Context) *logrus.Entry {
+	return reqinfo.Logger(ctx, s.log)
+}
+
+// values of created or imported customer checked by configured rules
+func itemFields(item *dto.CustomerItem) custval.Fields {
+	return custval.Fields{
+		FirstName: item.FirstName,
+		LastName:  item.LastName,
+		BirthDate: item.BirthDate,
+		Gender:    item.Gender,
+		Email:     item.Email,
+		Address:   item.Address,
+	}
+}
+
+// Emails are compared case insensitively by users, so they are stored in one form. Otherwise unique index lets
+// the same address to be used twice with different case.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+func (s *service) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.customerRepo.Purge(ctx, retention)
+}
 func (s *service) Update(ctx context.Context, customer *dto.UpdateCustomerArguments) error {
+	customer.Email = normalizeEmail(customer.Email)
+	if customer.Original != nil {
+		customer.Original.Email = normalizeEmail(customer.Original.Email)
+	}
 	if err := validate.Struct(customer); err != nil {
 		return codes.NewErr(codes.InvalidData, err.Error())
 	}
-	if !custval.IsValidBirthDate(customer.BirthDate) {
-		return codes.NewErr(codes.InvalidData, codes.KnownMessageCustomerInvalidAge)
+	err := s.rules.Check(custval.Fields{
+		FirstName: customer.FirstName,
+		LastName:  customer.LastName,
+		BirthDate: customer.BirthDate,
+		Gender:    customer.Gender,
+		Email:     customer.Email,
+		Address:   customer.Address,
+	})
+	if err != nil {
+		return codes.NewErr(codes.InvalidData, err.Error())
 	}
 	customerEntity := &models.Customer{
 		Id:        customer.Id,
@@ -88,47 +178,144 @@ func (s *service) Update(ctx context.Context, customer *dto.UpdateCustomerArgume
 		LastName:  customer.LastName,
 		Gender:    customer.Gender,
 		Address:   customer.Address,
+		Email:     customer.Email,
 		Hash:      customer.Hash,
 		BirthDate: customer.BirthDate,
 	}
-	if err := s.customerRepo.Update(ctx, customerEntity); err != nil {
+	for attempt := 1; ; attempt++ {
+		err := s.customerRepo.Update(ctx, customerEntity)
+		if err == nil {
+			return nil
+		}
 		if err == codes.NoRowsModified {
-			return codes.NewErr(codes.CustomerNotFound, codes.KnownMessageEditCustomerConflict)
+			return codes.NewErr(codes.CustomerNotFound, codes.KnownCustomerNotFound)
 		}
-		return err
+		if err == codes.CheckConstraintViolation {
+			return codes.NewErr(codes.InvalidData, codes.KnownMessageCustomerRulesViolated)
+		}
+		if err == codes.UniqueConstraintViolation {
+			return codes.NewErr(codes.EmailTaken, codes.KnownMessageGivenEmailBusyUseAnotherOne)
+		}
+		conflict, ok := err.(*customerrepo.ConflictError)
+		if !ok {
+			return err
+		}
+		// consumer receives current customer state, so user can compare it with his changes
+		conflictErr := &dto.UpdateConflict{Current: conflict.Current, Fields: []string{}}
+		if customer.Original == nil || attempt == maxMergeAttempts {
+			return codes.NewErrWithData(codes.OverwriteData, codes.KnownMessageEditCustomerConflict, conflictErr)
+		}
+		merged, conflictFields := mergeChanges(customer, conflict.Current)
+		if len(conflictFields) != 0 {
+			conflictErr.Fields = conflictFields
+			return codes.NewErrWithData(codes.OverwriteData, codes.KnownMessageEditCustomerConflict, conflictErr)
+		}
+		s.logger(ctx).Infof("concurrent changes of customer %d were merged", customer.Id)
+		customerEntity = merged
 	}
-	return nil
 }
 func (s *service) QueryList(ctx context.Context, args *dto.ListCustomersArguments) (*dto.ListCustomersResult, error) {
 	if err := validate.Struct(args); err != nil {
 		return nil, codes.NewErr(codes.InvalidData, err.Error())
 	}
+	// one extra customer is requested to know if there is one more page in requested direction
+	query := &customerrepo.ListQuery{
+		OrderBy:      args.OrderBy,
+		OrderByValue: args.OrderByValue,
+		Limit:        s.pageSize + 1,
+		Search:       args.SearchValue,
+		Deleted:      args.Deleted,
+	}
+	if args.Cursor != "" {
+		c, err := decodeCursor(args.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if !c.matches(args) {
+			return nil, errInvalidCursor
+		}
+		query.After = &customerrepo.Keyset{Value: c.Value, Id: c.Id, Rank: c.Rank}
+		query.Backward = c.Backward
+	}
 	var customers []models.Customer
 	var err error
 	if args.SearchValue == "" {
-		customers, err = s.customerRepo.QueryList(ctx, args.Page*CustomersPerPage, args.OrderBy, args.OrderByValue, CustomersPerPage)
+		customers, err = s.customerRepo.QueryList(ctx, query)
 	} else {
-		customers, err = s.customerRepo.SearchQueryList(ctx, args.Page*CustomersPerPage, args.OrderBy, args.OrderByValue, CustomersPerPage, args.SearchValue)
+		customers, err = s.customerRepo.SearchQueryList(ctx, query)
 	}
 	if err != nil {
 		return nil, err
 	}
+	hasMore := len(customers) > s.pageSize
+	if hasMore {
+		customers = customers[:s.pageSize]
+	}
+	if query.Backward {
+		for i, j := 0, len(customers)-1; i < j; i, j = i+1, j-1 {
+			customers[i], customers[j] = customers[j], customers[i]
+		}
+	}
 	res := &dto.ListCustomersResult{
 		Customers: []dto.ListCustomerResultItem{},
 	}
-	for _, v := range customers {
-		res.Customers = append(res.Customers, dto.ListCustomerResultItem{
-			Id:        v.Id,
-			Email:     v.Email,
-			FirstName: v.FirstName,
-			LastName:  v.LastName,
-			Gender:    v.Gender,
-			BirthDate: utils.FormatBirthDate(v.BirthDate),
-			Address:   v.Address,
-		})
+	for i := range customers {
+		res.Customers = append(res.Customers, *toListItem(&customers[i]))
+	}
+	// moving forward there is previous page if we came from some cursor, moving backward there is next page
+	hasNext, hasPrev := hasMore, query.After != nil
+	if query.Backward {
+		hasNext, hasPrev = query.After != nil, hasMore
+	}
+	if len(customers) != 0 {
+		if hasNext {
+			keyset, err := customerrepo.KeysetOf(&customers[len(customers)-1], args.OrderBy)
+			if err != nil {
+				return nil, err
+			}
+			res.NextCursor, res.HasNext = newCursor(keyset, args, false), true
+		}
+		if hasPrev {
+			keyset, err := customerrepo.KeysetOf(&customers[0], args.OrderBy)
+			if err != nil {
+				return nil, err
+			}
+			res.PrevCursor, res.HasPrev = newCursor(keyset, args, true), true
+		}
+	}
+	if args.WithTotal {
+		total, err := s.customerRepo.Count(ctx, args.SearchValue)
+		if err != nil {
+			return nil, err
+		}
+		res.Total = &total
 	}
 	return res, nil
 }
+func toListItem(customer *models.Customer) *dto.ListCustomerResultItem {
+	return &dto.ListCustomerResultItem{
+		Id:        customer.Id,
+		Email:     customer.Email,
+		FirstName: customer.FirstName,
+		LastName:  customer.LastName,
+		Gender:    customer.Gender,
+		BirthDate: utils.FormatBirthDate(customer.BirthDate),
+		Address:   customer.Address,
+	}
+}
+func (s *service) Export(ctx context.Context, args *dto.ExportCustomersArguments, each func(item *dto.ListCustomerResultItem) error) error {
+	if err := validate.Struct(args); err != nil {
+		return codes.NewErr(codes.InvalidData, err.Error())
+	}
+	query := &customerrepo.ListQuery{
+		OrderBy:      args.OrderBy,
+		OrderByValue: args.OrderByValue,
+		Search:       args.SearchValue,
+	}
+	return s.customerRepo.Export(ctx, query, func(customer *models.Customer) error {
+		return each(toListItem(customer))
+	})
+}
 func (s *service) GetById(ctx context.Context, customerId int) (*models.Customer, error) {
 	customer, err := s.customerRepo.GetById(ctx, customerId)
 	if err != nil {
@@ -141,3 +328,18 @@ func (s *service) GetById(ctx context.Context, customerId int) (*models.Customer
 
 	return customer, nil
 }
+
+func (s *service) History(ctx context.Context, customerId int) ([]models.CustomerAudit, error) {
+	history, err := s.customerRepo.History(ctx, customerId)
+	if err != nil {
+		return nil, err
+	}
+	// history of deleted customer is kept, so empty history means customer either doesn't exist
+	// or was created before audit was introduced
+	if len(history) == 0 {
+		if _, err := s.GetById(ctx, customerId); err != nil {
+			return nil, err
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,211 @@
+package customer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/custval"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	customerrepo "github.com/abdybaevae/customers-app/pkg/repos/customer"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	"github.com/sirupsen/logrus"
+)
+
+// repository stub, it returns prepared customers and remembers last list query
+type stubRepo struct {
+	customerrepo.CustomerRepo
+	customers []models.Customer
+	query     *customerrepo.ListQuery
+	// customer stored concurrently, first update is rejected with conflict when it's set
+	concurrent *models.Customer
+	updated    *models.Customer
+	updateErr  error
+	// emails of existing customers and customers created by CreateMany
+	emails  []string
+	created []*models.Customer
+}
+
+func (r *stubRepo) ExistingEmails(ctx context.Context, emails []string) ([]string, error) {
+	return r.emails, nil
+}
+
+func (r *stubRepo) CreateMany(ctx context.Context, customers []*models.Customer) error {
+	r.created = customers
+	return nil
+}
+
+func (r *stubRepo) Update(ctx context.Context, customer *models.Customer) error {
+	if r.concurrent != nil && customer.Hash != r.concurrent.Hash {
+		return &customerrepo.ConflictError{Current: r.concurrent}
+	}
+	if r.updateErr != nil {
+		return r.updateErr
+	}
+	r.updated = customer
+	return nil
+}
+
+func (r *stubRepo) QueryList(ctx context.Context, query *customerrepo.ListQuery) ([]models.Customer, error) {
+	r.query = query
+	if len(r.customers) > query.Limit {
+		return r.customers[:query.Limit], nil
+	}
+	return r.customers, nil
+}
+
+func customersRange(from, to int) []models.Customer {
+	customers := []models.Customer{}
+	for id := from; id <= to; id++ {
+		customers = append(customers, models.Customer{Id: id, FirstName: "name"})
+	}
+	return customers
+}
+
+func newListArgs(cursor string) *dto.ListCustomersArguments {
+	return &dto.ListCustomersArguments{OrderBy: "customer_first_name", OrderByValue: "asc", Cursor: cursor}
+}
+
+func TestQueryListPages(t *testing.T) {
+	repo := &stubRepo{customers: customersRange(1, CustomersPerPage+5)}
+	service := New(repo, logrus.NewEntry(logrus.New()), Options{})
+	first, err := service.QueryList(context.Background(), newListArgs(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Customers) != CustomersPerPage || !first.HasNext || first.HasPrev || first.PrevCursor != "" {
+		t.Fatal("unexpected first page", first.HasNext, first.HasPrev, len(first.Customers))
+	}
+	next, _ := decodeCursor(first.NextCursor)
+	if next.Id != CustomersPerPage || next.Backward {
+		t.Error("next cursor must point to last customer of page", next)
+	}
+
+	repo.customers = customersRange(CustomersPerPage+1, CustomersPerPage+5)
+	second, err := service.QueryList(context.Background(), newListArgs(first.NextCursor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.query.After.Id != CustomersPerPage || repo.query.Backward {
+		t.Error("cursor wasn't passed to repository", repo.query.After)
+	}
+	if second.HasNext || !second.HasPrev {
+		t.Error("last page must have only previous page", second.HasNext, second.HasPrev)
+	}
+
+	// moving backward repository returns customers in reversed order
+	repo.customers = customersRange(1, CustomersPerPage)
+	for i, j := 0, len(repo.customers)-1; i < j; i, j = i+1, j-1 {
+		repo.customers[i], repo.customers[j] = repo.customers[j], repo.customers[i]
+	}
+	back, err := service.QueryList(context.Background(), newListArgs(second.PrevCursor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !repo.query.Backward || back.Customers[0].Id != 1 || !back.HasNext || back.HasPrev {
+		t.Error("unexpected previous page", back.HasNext, back.HasPrev, back.Customers[0].Id)
+	}
+}
+
+func TestConfiguredOptions(t *testing.T) {
+	repo := &stubRepo{customers: customersRange(1, 10)}
+	service := New(repo, logrus.NewEntry(logrus.New()), Options{PageSize: 3, Rules: custval.Rules{Ages: custval.AgeLimits{Min: 30, Max: 40}, Genders: []string{"male"}, MaxNameLength: 10, MaxEmailLength: 100, MaxAddressLength: 100}})
+	page, err := service.QueryList(context.Background(), newListArgs(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.query.Limit != 4 || len(page.Customers) != 3 || !page.HasNext {
+		t.Error("configured page size is not used", repo.query.Limit, len(page.Customers))
+	}
+	customer := &dto.CreateCustomerArguments{CustomerItem: dto.CustomerItem{
+		FirstName: "John", LastName: "Doe", BirthDate: time.Now().AddDate(-20, 0, 0), Gender: "male", Email: "john@example.com",
+	}}
+	_, err = service.Create(context.Background(), customer)
+	if errCode, ok := err.(codes.ErrorCode); !ok || errCode.Message() != "Customer age must be between 30 and 40 inclusively." {
+		t.Error("configured age limits are not used", err)
+	}
+}
+
+func TestQueryListRejectsForeignCursor(t *testing.T) {
+	service := New(&stubRepo{}, logrus.NewEntry(logrus.New()), Options{})
+	foreign := []string{
+		"garbage",
+		encodeCursor(&cursor{OrderBy: "customer_email", OrderByValue: "asc", Id: 1}),
+		encodeCursor(&cursor{OrderBy: "customer_first_name", OrderByValue: "asc", Search: searchHash("john"), Id: 1}),
+		encodeCursor(&cursor{OrderBy: "customer_first_name", OrderByValue: "asc", Deleted: true, Id: 1}),
+	}
+	for _, value := range foreign {
+		_, err := service.QueryList(context.Background(), newListArgs(value))
+		if errCode, ok := err.(codes.ErrorCode); !ok || errCode.Code() != codes.InvalidData {
+			t.Error("invalid cursor must be rejected", value, err)
+		}
+	}
+}
+
+func newUpdateArgs(lastName, address string) *dto.UpdateCustomerArguments {
+	birthDate := time.Now().AddDate(-30, 0, 0)
+	return &dto.UpdateCustomerArguments{
+		Id:        1,
+		FirstName: "John",
+		LastName:  lastName,
+		BirthDate: birthDate,
+		Gender:    "male",
+		Email:     "john@doe.com",
+		Address:   address,
+		Hash:      "aaaaaaaaaaaaaaaaaaaa",
+		Original:  &dto.CustomerFields{FirstName: "John", LastName: "Doe", BirthDate: birthDate, Gender: "male", Email: "john@doe.com", Address: "Street"},
+	}
+}
+
+func TestUpdateMergesDifferentFields(t *testing.T) {
+	args := newUpdateArgs("Smith", "Street")
+	repo := &stubRepo{concurrent: &models.Customer{
+		Id: 1, FirstName: "John", LastName: "Doe", BirthDate: args.BirthDate, Gender: "male", Email: "john@doe.com", Address: "Avenue", Hash: "bbbbbbbbbbbbbbbbbbbb",
+	}}
+	service := New(repo, logrus.NewEntry(logrus.New()), Options{})
+	if err := service.Update(context.Background(), args); err != nil {
+		t.Fatal(err)
+	}
+	if repo.updated.LastName != "Smith" || repo.updated.Address != "Avenue" || repo.updated.Hash != repo.concurrent.Hash {
+		t.Error("changes weren't merged", repo.updated)
+	}
+}
+
+func TestUpdateReportsConflictingFields(t *testing.T) {
+	args := newUpdateArgs("Smith", "Street")
+	repo := &stubRepo{concurrent: &models.Customer{
+		Id: 1, FirstName: "John", LastName: "Brown", BirthDate: args.BirthDate, Gender: "male", Email: "john@doe.com", Address: "Avenue", Hash: "bbbbbbbbbbbbbbbbbbbb",
+	}}
+	service := New(repo, logrus.NewEntry(logrus.New()), Options{})
+	err := service.Update(context.Background(), args)
+	dataErr, ok := err.(codes.DataErrorCode)
+	if !ok || dataErr.Code() != codes.OverwriteData {
+		t.Fatal("expected conflict", err)
+	}
+	conflict := dataErr.Data().(*dto.UpdateConflict)
+	if len(conflict.Fields) != 1 || conflict.Fields[0] != FieldLastName || conflict.Current != repo.concurrent {
+		t.Error("unexpected conflict", conflict.Fields)
+	}
+	if repo.updated != nil {
+		t.Error("conflicting update was stored")
+	}
+}
+
+func TestUpdateEmail(t *testing.T) {
+	repo := &stubRepo{}
+	service := New(repo, logrus.NewEntry(logrus.New()), Options{})
+	args := newUpdateArgs("Doe", "Street")
+	args.Email = "  John.Smith@Doe.COM "
+	if err := service.Update(context.Background(), args); err != nil {
+		t.Fatal(err)
+	}
+	if repo.updated.Email != "john.smith@doe.com" {
+		t.Error("email wasn't normalized", repo.updated.Email)
+	}
+	repo.updateErr = codes.UniqueConstraintViolation
+	err := service.Update(context.Background(), newUpdateArgs("Doe", "Street"))
+	if errCode, ok := err.(codes.ErrorCode); !ok || errCode.Code() != codes.EmailTaken {
+		t.Error("taken email must be reported", err)
+	}
+}
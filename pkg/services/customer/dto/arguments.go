@@ -2,50 +2,125 @@ package dto
 
 import (
 	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/models"
 )
 
 // It's always better to have data transfer object per each layer. services has their own dto, repos deals with entities
 // more flexibility when you gonna change some endpoints / entities.
 type CustomerItem struct {
-	FirstName string    `validate:"required,max=100"`
-	LastName  string    `validate:"required,max=100"`
+	FirstName string    `validate:"required"`
+	LastName  string    `validate:"required"`
 	BirthDate time.Time `validate:"required"`
-	Gender    string    `validate:"required,oneof=female male"`
-	Email     string    `validate:"required,email"`
-	Address   string
-	Hash      string
+	// lengths, gender and age are checked by configured rules(custval.Rules)
+	Gender  string `validate:"required"`
+	Email   string `validate:"required,email"`
+	Address string
+	Hash    string
 }
 type CreateCustomerArguments struct {
 	CustomerItem
 }
 type UpdateCustomerArguments struct {
 	Id        int       `validate:"required"`
-	FirstName string    `validate:"required,max=100"`
-	LastName  string    `validate:"required,max=100"`
+	FirstName string    `validate:"required"`
+	LastName  string    `validate:"required"`
 	BirthDate time.Time `validate:"required"`
-	Gender    string    `validate:"required,oneof=female male"`
+	// lengths, gender and age are checked by configured rules(custval.Rules)
+	Gender string `validate:"required"`
+	Email  string `validate:"required,email"`
 	// random hash string length must be syncronized here too
 	Hash    string `validate:"required,len=20"`
 	Address string
+	// customer values client started editing from. If customer was changed concurrently, changes of different fields
+	// are merged, without original values whole update is rejected.
+	Original *CustomerFields
+}
+
+// editable customer fields
+type CustomerFields struct {
+	FirstName string
+	LastName  string
+	BirthDate time.Time
+	Gender    string
+	Email     string
+	Address   string
+}
+
+// Customer was changed concurrently and changes can't be merged
+type UpdateConflict struct {
+	// current customer state
+	Current *models.Customer `json:"current"`
+	// fields changed both by client and by someone else to different values(firstName, lastName, birthDate, gender, email, address),
+	// empty if original values weren't provided
+	Fields []string `json:"fields"`
 }
 type ListCustomersArguments struct {
-	Page         int    `validate:"min=0"`
+	// opaque page cursor received in previous result(next or previous one), empty for the first page
+	Cursor       string `validate:"max=1000"`
+	SearchValue  string `validate:"max=100"`
+	OrderBy      string `validate:"required,oneof=customer_first_name customer_last_name customer_birth_date customer_address customer_email"`
+	OrderByValue string `validate:"required,oneof=asc desc"`
+	// count all matching customers, it's additional query so it's requested explicitly
+	WithTotal bool
+	// list deleted customers(trash) instead of active ones
+	Deleted bool
+}
+
+// Export includes all customers of list, so it has no cursor
+type ExportCustomersArguments struct {
 	SearchValue  string `validate:"max=100"`
 	OrderBy      string `validate:"required,oneof=customer_first_name customer_last_name customer_birth_date customer_address customer_email"`
 	OrderByValue string `validate:"required,oneof=asc desc"`
 }
 type ListCustomerResultItem struct {
-	Id        int
-	Email     string
-	FirstName string
-	LastName  string
-	Address   string
-	BirthDate string
-	Gender    string
+	Id        int    `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Address   string `json:"address"`
+	BirthDate string `json:"birthDate"`
+	Gender    string `json:"gender"`
 }
 type ListCustomersResult struct {
-	Customers []ListCustomerResultItem
+	Customers  []ListCustomerResultItem `json:"customers"`
+	NextCursor string                   `json:"nextCursor,omitempty"`
+	PrevCursor string                   `json:"prevCursor,omitempty"`
+	HasNext    bool                     `json:"hasNext"`
+	HasPrev    bool                     `json:"hasPrev"`
+	// filled only if it was requested with WithTotal
+	Total *int `json:"total,omitempty"`
 }
 type GetByIdResult struct {
 	CustomerItem
 }
+
+// Customers read from imported file
+type ImportCustomersArguments struct {
+	Rows []ImportRow
+	// only validate rows and report errors, nothing is stored
+	DryRun bool
+	// store valid rows and report invalid ones, otherwise single invalid row rejects whole import
+	SkipInvalid bool
+}
+type ImportRow struct {
+	// row number in file(header is the first one), it's used in errors report
+	Line int
+	CustomerItem
+	// row can't be converted to customer(e.g. malformed birthdate)
+	ParseError string
+}
+type ImportRowError struct {
+	Line    int    `json:"line"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+}
+type ImportCustomersResult struct {
+	Total int `json:"total"`
+	// rows passed validation
+	Valid int `json:"valid"`
+	// stored customers, zero for dry run or rejected import
+	Imported int              `json:"imported"`
+	DryRun   bool             `json:"dryRun"`
+	Errors   []ImportRowError `json:"errors"`
+}
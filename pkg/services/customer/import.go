@@ -0,0 +1,187 @@
+package customer
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/go-playground/validator/v10"
+)
+
+// whole import is stored in one transaction, so it's size is limited
+const MaxImportRows = 5000
+
+// columns which import file must have, address column is optional
+var requiredImportColumns = []string{"firstname", "lastname", "birthdate", "gender", "email"}
+
+// Read customers from csv(comma is ',') or tsv(comma is '\t') file. First row is header with column names,
+// names are compared without case and punctuation, so "firstName", "First name" and "first_name" are the same column.
+// Rows which can't be converted to customer are returned with parse error, they are reported by Import.
+func ReadImportFile(r io.Reader, comma rune) ([]dto.ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	// tab separated files usually don't quote values
+	reader.LazyQuotes = comma == '\t'
+	header, err := reader.Read()
+	if err != nil {
+		return nil, codes.NewErr(codes.InvalidData, codes.KnownMessageImportMalformedFile)
+	}
+	position := map[string]int{}
+	for i, name := range header {
+		position[importColumnName(name)] = i
+	}
+	for _, column := range requiredImportColumns {
+		if _, ok := position[column]; !ok {
+			return nil, codes.NewErr(codes.InvalidData, codes.KnownMessageImportMissingColumns)
+		}
+	}
+	rows := []dto.ImportRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, codes.NewErr(codes.InvalidData, codes.KnownMessageImportMalformedFile)
+		}
+		if len(rows) == MaxImportRows {
+			return nil, codes.NewErr(codes.InvalidData, codes.KnownMessageImportTooManyRows)
+		}
+		value := func(column string) string {
+			i, ok := position[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		// line in file, not number of record: empty lines are skipped and quoted value can span several lines
+		line, _ := reader.FieldPos(0)
+		row := dto.ImportRow{
+			Line: line,
+			CustomerItem: dto.CustomerItem{
+				FirstName: value("firstname"),
+				LastName:  value("lastname"),
+				Gender:    strings.ToLower(value("gender")),
+				Email:     value("email"),
+				Address:   value("address"),
+			},
+		}
+		birthDate, err := utils.ParseBirthDate(value("birthdate"))
+		if err != nil {
+			row.ParseError = codes.KnownMessageCustomerInvalidBirthDate
+		}
+		row.BirthDate = birthDate
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func importColumnName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// Every row is checked with the same rules as single created customer, emails must be unique within file
+// and must not be used by existing customers.
+func (s *service) Import(ctx context.Context, args *dto.ImportCustomersArguments) (*dto.ImportCustomersResult, error) {
+	result := &dto.ImportCustomersResult{Total: len(args.Rows), DryRun: args.DryRun, Errors: []dto.ImportRowError{}}
+	valid := []dto.ImportRow{}
+	seen := map[string]bool{}
+	for _, row := range args.Rows {
+		row.Email = normalizeEmail(row.Email)
+		if message := s.validateImportRow(&row); message != "" {
+			result.Errors = append(result.Errors, dto.ImportRowError{Line: row.Line, Email: row.Email, Message: message})
+			continue
+		}
+		if seen[row.Email] {
+			result.Errors = append(result.Errors, dto.ImportRowError{Line: row.Line, Email: row.Email, Message: codes.KnownMessageImportDuplicateEmail})
+			continue
+		}
+		seen[row.Email] = true
+		valid = append(valid, row)
+	}
+	emails := []string{}
+	for _, row := range valid {
+		emails = append(emails, row.Email)
+	}
+	taken := map[string]bool{}
+	if len(emails) != 0 {
+		existing, err := s.customerRepo.ExistingEmails(ctx, emails)
+		if err != nil {
+			return nil, err
+		}
+		for _, email := range existing {
+			taken[email] = true
+		}
+	}
+	customers := []*models.Customer{}
+	for _, row := range valid {
+		if taken[row.Email] {
+			result.Errors = append(result.Errors, dto.ImportRowError{Line: row.Line, Email: row.Email, Message: codes.KnownMessageGivenEmailBusyUseAnotherOne})
+			continue
+		}
+		customers = append(customers, &models.Customer{
+			FirstName: row.FirstName,
+			LastName:  row.LastName,
+			BirthDate: row.BirthDate,
+			Gender:    row.Gender,
+			Email:     row.Email,
+			Address:   row.Address,
+			Hash:      utils.GenCustomerHash(),
+		})
+	}
+	sort.Slice(result.Errors, func(i, j int) bool { return result.Errors[i].Line < result.Errors[j].Line })
+	result.Valid = len(customers)
+	if args.DryRun || len(customers) == 0 || len(result.Errors) != 0 && !args.SkipInvalid {
+		return result, nil
+	}
+	if err := s.customerRepo.CreateMany(ctx, customers); err != nil {
+		// email was taken after check
+		if err == codes.UniqueConstraintViolation {
+			return nil, codes.NewErr(codes.EmailTaken, codes.KnownMessageGivenEmailBusyUseAnotherOne)
+		}
+		if err == codes.CheckConstraintViolation {
+			return nil, codes.NewErr(codes.InvalidData, codes.KnownMessageCustomerRulesViolated)
+		}
+		return nil, err
+	}
+	result.Imported = len(customers)
+	s.logger(ctx).Infof("%d customers were imported", result.Imported)
+	return result, nil
+}
+
+// error message for invalid row, empty if row is valid
+func (s *service) validateImportRow(row *dto.ImportRow) string {
+	if row.ParseError != "" {
+		return row.ParseError
+	}
+	if err := validate.Struct(&dto.CreateCustomerArguments{CustomerItem: row.CustomerItem}); err != nil {
+		validationErrs, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return err.Error()
+		}
+		messages := []string{}
+		for _, fieldErr := range validationErrs {
+			messages = append(messages, fmt.Sprintf("%s is invalid(%s)", fieldErr.Field(), fieldErr.Tag()))
+		}
+		return strings.Join(messages, ", ")
+	}
+	if err := s.rules.Check(itemFields(&row.CustomerItem)); err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package customer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+func importFile() string {
+	birthDate := utils.FormatBirthDate(time.Now().AddDate(-30, 0, 0))
+	return strings.Join([]string{
+		"First name\tLast_Name\tBIRTHDATE\tgender\temail",
+		"John\tDoe\t" + birthDate + "\tmale\tJohn@Doe.com",
+		"Jane\tDoe\t02.01.1990\tfemale\tjane@doe.com",
+		"\tSmith\t" + birthDate + "\tmale\tsmith@doe.com",
+		"Johnny\tDoe\t" + birthDate + "\tmale\tjohn@doe.com ",
+		"Ann\tTaken\t" + birthDate + "\tfemale\ttaken@doe.com",
+		"Mary\tLee\t" + birthDate + "\tfemale\tmary@lee.com",
+	}, "\n")
+}
+
+func TestReadImportFile(t *testing.T) {
+	rows, err := ReadImportFile(strings.NewReader(importFile()), '\t')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 6 || rows[0].Line != 2 || rows[0].FirstName != "John" || rows[0].Gender != "male" {
+		t.Fatal("unexpected rows", rows)
+	}
+	if rows[0].ParseError != "" || rows[1].ParseError != codes.KnownMessageCustomerInvalidBirthDate {
+		t.Error("malformed birthdate must be reported", rows[0].ParseError, rows[1].ParseError)
+	}
+	birthDate := utils.FormatBirthDate(time.Now().AddDate(-30, 0, 0))
+	file := "firstName,lastName,birthDate,gender,email,address\n\nJohn,Doe," + birthDate + ",male,john@doe.com,\"Main st. 1\nApt. 2\"\n" +
+		"Jane,Doe," + birthDate + ",female,jane@doe.com,"
+	rows, err = ReadImportFile(strings.NewReader(file), ',')
+	if err != nil || len(rows) != 2 || rows[0].Line != 3 || rows[1].Line != 5 || rows[0].Address != "Main st. 1\nApt. 2" {
+		t.Error("rows must have lines of file", rows, err)
+	}
+	_, err = ReadImportFile(strings.NewReader("firstName,lastName\nJohn,Doe"), ',')
+	if errCode, ok := err.(codes.ErrorCode); !ok || errCode.Message() != codes.KnownMessageImportMissingColumns {
+		t.Error("missing columns must be reported", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	rows, _ := ReadImportFile(strings.NewReader(importFile()), '\t')
+	repo := &stubRepo{emails: []string{"taken@doe.com"}}
+	service := New(repo, logrus.NewEntry(logrus.New()), Options{})
+	result, err := service.Import(context.Background(), &dto.ImportCustomersArguments{Rows: rows, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []int{}
+	for _, rowErr := range result.Errors {
+		lines = append(lines, rowErr.Line)
+	}
+	// bad birthdate, missing name, duplicate email within file, email of existing customer
+	if len(lines) != 4 || lines[0] != 3 || lines[1] != 4 || lines[2] != 5 || lines[3] != 6 || result.Valid != 2 {
+		t.Fatal("unexpected dry run report", result)
+	}
+	if repo.created != nil {
+		t.Error("dry run must not store customers")
+	}
+
+	result, _ = service.Import(context.Background(), &dto.ImportCustomersArguments{Rows: rows})
+	if result.Imported != 0 || repo.created != nil {
+		t.Error("import with invalid rows must be rejected", result.Imported)
+	}
+
+	result, _ = service.Import(context.Background(), &dto.ImportCustomersArguments{Rows: rows, SkipInvalid: true})
+	if result.Imported != 2 || len(repo.created) != 2 || repo.created[0].Email != "john@doe.com" {
+		t.Error("valid rows must be imported", result.Imported)
+	}
+}
@@ -0,0 +1,53 @@
+package customer
+
+import (
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+)
+
+// names of editable fields in update conflict
+const (
+	FieldFirstName = "firstName"
+	FieldLastName  = "lastName"
+	FieldBirthDate = "birthDate"
+	FieldGender    = "gender"
+	FieldEmail     = "email"
+	FieldAddress   = "address"
+)
+
+// how many times update is retried with merged changes, if customer keeps changing concurrently
+const maxMergeAttempts = 3
+
+// Three way merge of client changes with current customer state. Field changed only by client takes client value,
+// field changed only by someone else keeps current value. Field changed by both to different values is conflict.
+func mergeChanges(args *dto.UpdateCustomerArguments, current *models.Customer) (*models.Customer, []string) {
+	merged := &models.Customer{
+		Id:   current.Id,
+		Hash: current.Hash,
+	}
+	conflicts := []string{}
+	mergeField := func(name, mine, original, theirs string) string {
+		mineChanged, theirsChanged := mine != original, theirs != original
+		if mineChanged && theirsChanged && mine != theirs {
+			conflicts = append(conflicts, name)
+		}
+		if mineChanged {
+			return mine
+		}
+		return theirs
+	}
+	original := args.Original
+	merged.FirstName = mergeField(FieldFirstName, args.FirstName, original.FirstName, current.FirstName)
+	merged.LastName = mergeField(FieldLastName, args.LastName, original.LastName, current.LastName)
+	merged.Gender = mergeField(FieldGender, args.Gender, original.Gender, current.Gender)
+	merged.Email = mergeField(FieldEmail, args.Email, original.Email, current.Email)
+	merged.Address = mergeField(FieldAddress, args.Address, original.Address, current.Address)
+	birthDate := mergeField(FieldBirthDate, utils.FormatBirthDate(args.BirthDate), utils.FormatBirthDate(original.BirthDate), utils.FormatBirthDate(current.BirthDate))
+	if birthDate == utils.FormatBirthDate(args.BirthDate) {
+		merged.BirthDate = args.BirthDate
+	} else {
+		merged.BirthDate = current.BirthDate
+	}
+	return merged, conflicts
+}
@@ -0,0 +1,62 @@
+package customer
+
+import (
+	"context"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/metrics"
+	"github.com/abdybaevae/customers-app/pkg/services/customer/dto"
+)
+
+// Customer service decorator which counts successful changes of customers and rejected concurrent updates
+type instrumentedService struct {
+	CustomerService
+	created   *metrics.Counter
+	updated   *metrics.Counter
+	deleted   *metrics.Counter
+	conflicts *metrics.Counter
+}
+
+func Instrument(service CustomerService, registry *metrics.Registry) CustomerService {
+	return &instrumentedService{
+		CustomerService: service,
+		created:         registry.Counter("customers_created_total", "Customers created one by one or by import."),
+		updated:         registry.Counter("customers_updated_total", "Customers updated, including merged concurrent updates."),
+		deleted:         registry.Counter("customers_deleted_total", "Customers deleted(they can be restored till purge)."),
+		conflicts:       registry.Counter("customers_update_conflicts_total", "Updates rejected because of concurrent changes of the same fields."),
+	}
+}
+
+func (s *instrumentedService) Create(ctx context.Context, customer *dto.CreateCustomerArguments) (int, error) {
+	id, err := s.CustomerService.Create(ctx, customer)
+	if err == nil {
+		s.created.Inc()
+	}
+	return id, err
+}
+
+func (s *instrumentedService) Import(ctx context.Context, args *dto.ImportCustomersArguments) (*dto.ImportCustomersResult, error) {
+	result, err := s.CustomerService.Import(ctx, args)
+	if err == nil {
+		s.created.Add(float64(result.Imported))
+	}
+	return result, err
+}
+
+func (s *instrumentedService) Update(ctx context.Context, args *dto.UpdateCustomerArguments) error {
+	err := s.CustomerService.Update(ctx, args)
+	if err == nil {
+		s.updated.Inc()
+	} else if errCode, ok := err.(codes.ErrorCode); ok && errCode.Code() == codes.OverwriteData {
+		s.conflicts.Inc()
+	}
+	return err
+}
+
+func (s *instrumentedService) DeleteById(ctx context.Context, customerId int) error {
+	err := s.CustomerService.DeleteById(ctx, customerId)
+	if err == nil {
+		s.deleted.Inc()
+	}
+	return err
+}
@@ -0,0 +1,29 @@
+package customer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/abdybaevae/customers-app/pkg/metrics"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	"github.com/sirupsen/logrus"
+)
+
+func TestInstrumentCountsUpdates(t *testing.T) {
+	args := newUpdateArgs("Smith", "Street")
+	repo := &stubRepo{}
+	service := Instrument(New(repo, logrus.NewEntry(logrus.New()), Options{}), metrics.NewRegistry())
+	if err := service.Update(context.Background(), args); err != nil {
+		t.Fatal(err)
+	}
+	repo.concurrent = &models.Customer{
+		Id: 1, FirstName: "John", LastName: "Brown", BirthDate: args.BirthDate, Gender: "male", Email: "john@doe.com", Address: "Avenue", Hash: "bbbbbbbbbbbbbbbbbbbb",
+	}
+	if err := service.Update(context.Background(), newUpdateArgs("Smith", "Street")); err == nil {
+		t.Fatal("expected conflict")
+	}
+	instrumented := service.(*instrumentedService)
+	if instrumented.updated.Value() != 1 || instrumented.conflicts.Value() != 1 {
+		t.Error("unexpected counters", instrumented.updated.Value(), instrumented.conflicts.Value())
+	}
+}
@@ -0,0 +1,114 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	idempotencyrepo "github.com/abdybaevae/customers-app/pkg/repos/idempotency"
+)
+
+const (
+	// default period during which retry with the same key receives stored response
+	DefaultRetention = 24 * time.Hour
+	// default time after which request which isn't completed is considered lost(e.g. server has crashed while handling it)
+	DefaultLockTimeout = time.Minute
+)
+
+// Idempotency keys of unsafe requests. Client sends random key with request and repeats it on retry, the first request
+// is handled and its response is stored, retries receive the stored response instead of repeating the change.
+// Keys are separate for every owner(client), so one client can't read response of another.
+type IdempotencyService interface {
+	// Reserve key for request with given fingerprint. Response of completed request with the same key is returned,
+	// nil means request must be handled and then completed or released.
+	// codes.IdempotencyKeyReused is returned if key was used with another fingerprint and
+	// codes.IdempotencyKeyInProgress if the first request with key isn't completed yet. Request which isn't completed
+	// in lock timeout is taken over by retry.
+	Begin(ctx context.Context, owner, key, fingerprint string) (stored *models.IdempotentRequest, err error)
+	// store response of reserved request
+	Complete(ctx context.Context, request *models.IdempotentRequest) (err error)
+	// release key of failed request, so it can be retried
+	Release(ctx context.Context, owner, key string) (err error)
+	// remove keys older than retention, returns count of removed keys
+	Purge(ctx context.Context) (count int64, err error)
+}
+
+type service struct {
+	idempotencyRepo idempotencyrepo.IdempotencyRepo
+	retention       time.Duration
+	lockTimeout     time.Duration
+}
+
+// Configurable service options, zero values are replaced with defaults
+type Options struct {
+	Retention time.Duration
+	// max time of handling request, retry takes over request which isn't completed after it
+	LockTimeout time.Duration
+}
+
+func New(idempotencyRepo idempotencyrepo.IdempotencyRepo, opts Options) IdempotencyService {
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultRetention
+	}
+	if opts.LockTimeout <= 0 {
+		opts.LockTimeout = DefaultLockTimeout
+	}
+	return &service{idempotencyRepo: idempotencyRepo, retention: opts.Retention, lockTimeout: opts.LockTimeout}
+}
+
+func (s *service) Begin(ctx context.Context, owner, key, fingerprint string) (*models.IdempotentRequest, error) {
+	request := &models.IdempotentRequest{Owner: owner, Key: key, Fingerprint: fingerprint}
+	reserved, err := s.idempotencyRepo.Reserve(ctx, request)
+	if err != nil || reserved {
+		return nil, err
+	}
+	// key isn't purged yet, but it's already expired
+	expired, err := s.idempotencyRepo.DeleteExpired(ctx, owner, key, s.retention)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return s.Begin(ctx, owner, key, fingerprint)
+	}
+	stored, err := s.idempotencyRepo.Get(ctx, owner, key)
+	if err == sql.ErrNoRows {
+		// first request failed and released key right now
+		return nil, inProgress()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.Fingerprint != fingerprint {
+		return nil, codes.NewErr(codes.IdempotencyKeyReused, codes.KnownMessageIdempotencyKeyReused)
+	}
+	if stored.IsCompleted() {
+		return stored, nil
+	}
+	// first request which isn't completed in lock timeout is lost, retry handles it unless another retry has taken it over already
+	locked, err := s.idempotencyRepo.Relock(ctx, owner, key, s.lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, inProgress()
+	}
+	return nil, nil
+}
+
+func inProgress() error {
+	return codes.NewErr(codes.IdempotencyKeyInProgress, codes.KnownMessageIdempotencyKeyInProgress)
+}
+
+func (s *service) Complete(ctx context.Context, request *models.IdempotentRequest) error {
+	return s.idempotencyRepo.Complete(ctx, request)
+}
+
+func (s *service) Release(ctx context.Context, owner, key string) error {
+	return s.idempotencyRepo.Delete(ctx, owner, key)
+}
+
+func (s *service) Purge(ctx context.Context) (int64, error) {
+	return s.idempotencyRepo.DeleteOlderThan(ctx, s.retention)
+}
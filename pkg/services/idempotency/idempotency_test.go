@@ -0,0 +1,131 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	idempotencyrepo "github.com/abdybaevae/customers-app/pkg/repos/idempotency"
+)
+
+// in memory repository of keys
+type stubRepo struct {
+	idempotencyrepo.IdempotencyRepo
+	requests map[string]*models.IdempotentRequest
+}
+
+func newStubRepo() *stubRepo {
+	return &stubRepo{requests: map[string]*models.IdempotentRequest{}}
+}
+
+func (r *stubRepo) Reserve(ctx context.Context, request *models.IdempotentRequest) (bool, error) {
+	if _, ok := r.requests[request.Owner+"/"+request.Key]; ok {
+		return false, nil
+	}
+	request.CreatedAt, request.LockedAt = time.Now(), time.Now()
+	copy := *request
+	r.requests[request.Owner+"/"+request.Key] = &copy
+	return true, nil
+}
+
+func (r *stubRepo) Get(ctx context.Context, owner, key string) (*models.IdempotentRequest, error) {
+	request, ok := r.requests[owner+"/"+key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copy := *request
+	return &copy, nil
+}
+
+func (r *stubRepo) Relock(ctx context.Context, owner, key string, timeout time.Duration) (bool, error) {
+	request, ok := r.requests[owner+"/"+key]
+	if !ok || request.IsCompleted() || time.Since(request.LockedAt) <= timeout {
+		return false, nil
+	}
+	request.LockedAt = time.Now()
+	return true, nil
+}
+
+func (r *stubRepo) DeleteExpired(ctx context.Context, owner, key string, retention time.Duration) (bool, error) {
+	request, ok := r.requests[owner+"/"+key]
+	if !ok || time.Since(request.CreatedAt) <= retention {
+		return false, nil
+	}
+	delete(r.requests, owner+"/"+key)
+	return true, nil
+}
+
+func (r *stubRepo) Complete(ctx context.Context, request *models.IdempotentRequest) error {
+	stored := r.requests[request.Owner+"/"+request.Key]
+	stored.Status, stored.Headers, stored.Body = request.Status, request.Headers, request.Body
+	return nil
+}
+
+func (r *stubRepo) Delete(ctx context.Context, owner, key string) error {
+	delete(r.requests, owner+"/"+key)
+	return nil
+}
+
+func errCode(err error) codes.Code {
+	if errCode, ok := err.(codes.ErrorCode); ok {
+		return errCode.Code()
+	}
+	return ""
+}
+
+func TestBeginAndReplay(t *testing.T) {
+	repo := newStubRepo()
+	service := New(repo, Options{Retention: time.Hour})
+	ctx := context.Background()
+	if stored, err := service.Begin(ctx, "admin", "retry-1", "create john"); err != nil || stored != nil {
+		t.Fatal("first request must be handled", stored, err)
+	}
+	if _, err := service.Begin(ctx, "admin", "retry-1", "create john"); errCode(err) != codes.IdempotencyKeyInProgress {
+		t.Error("retry of unfinished request must be rejected", err)
+	}
+	status := 201
+	service.Complete(ctx, &models.IdempotentRequest{Owner: "admin", Key: "retry-1", Status: &status, Body: []byte("created")})
+	stored, err := service.Begin(ctx, "admin", "retry-1", "create john")
+	if err != nil || stored == nil || *stored.Status != 201 || string(stored.Body) != "created" {
+		t.Fatal("retry must receive stored response", stored, err)
+	}
+	if _, err := service.Begin(ctx, "admin", "retry-1", "create jane"); errCode(err) != codes.IdempotencyKeyReused {
+		t.Error("key must not be reused for another request", err)
+	}
+	if stored, err := service.Begin(ctx, "editor", "retry-1", "create jane"); err != nil || stored != nil {
+		t.Error("keys of owners must be separate", stored, err)
+	}
+}
+
+func TestReleaseAndExpiry(t *testing.T) {
+	repo := newStubRepo()
+	service := New(repo, Options{Retention: time.Hour})
+	ctx := context.Background()
+	service.Begin(ctx, "admin", "retry-1", "create john")
+	service.Release(ctx, "admin", "retry-1")
+	if stored, err := service.Begin(ctx, "admin", "retry-1", "create john"); err != nil || stored != nil {
+		t.Error("released key must be reserved again", stored, err)
+	}
+	repo.requests["admin/retry-1"].CreatedAt = time.Now().Add(-2 * time.Hour)
+	if stored, err := service.Begin(ctx, "admin", "retry-1", "create jane"); err != nil || stored != nil {
+		t.Error("expired key must be reserved again", stored, err)
+	}
+}
+
+// server has crashed while the first request was handled
+func TestLockTimeout(t *testing.T) {
+	repo := newStubRepo()
+	service := New(repo, Options{Retention: time.Hour, LockTimeout: time.Minute})
+	ctx := context.Background()
+	service.Begin(ctx, "admin", "retry-1", "create john")
+	repo.requests["admin/retry-1"].LockedAt = time.Now().Add(-2 * time.Minute)
+	if stored, err := service.Begin(ctx, "admin", "retry-1", "create john"); err != nil || stored != nil {
+		t.Fatal("retry must take over lost request", stored, err)
+	}
+	if _, err := service.Begin(ctx, "admin", "retry-1", "create john"); errCode(err) != codes.IdempotencyKeyInProgress {
+		t.Error("request taken over by retry must be locked again", err)
+	}
+}
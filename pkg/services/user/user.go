@@ -0,0 +1,216 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	userrepo "github.com/abdybaevae/customers-app/pkg/repos/user"
+	"github.com/abdybaevae/customers-app/pkg/reqinfo"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// default lifetime of session, user has to log in again after it
+const DefaultSessionLifetime = 12 * time.Hour
+
+// bcrypt uses only first 72 bytes of password, longer passwords are rejected instead of silently truncated
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// User accounts and sessions of customer ui. Passwords are stored as bcrypt hashes, sessions are identified
+// by random token that is given to browser, database keeps only sha256 of token.
+type UserService interface {
+	// Check credentials and start new session, token must be given back to Authenticate
+	Login(ctx context.Context, login, password string) (token string, user *models.User, err error)
+	// user of active session, codes.Unauthorized if session doesn't exist or expired
+	Authenticate(ctx context.Context, token string) (user *models.User, err error)
+	// end session, unknown token is ignored
+	Logout(ctx context.Context, token string) (err error)
+	Create(ctx context.Context, login, password string, role models.Role) (user *models.User, err error)
+	// change password of user, all his sessions are ended
+	SetPassword(ctx context.Context, login, password string) (err error)
+	// change role of user, all his sessions are ended
+	SetRole(ctx context.Context, login string, role models.Role) (err error)
+	List(ctx context.Context) (users []models.User, err error)
+	// remove expired sessions, returns count of removed sessions
+	PurgeSessions(ctx context.Context) (count int64, err error)
+}
+
+type service struct {
+	userRepo        userrepo.UserRepo
+	log             *logrus.Entry
+	sessionLifetime time.Duration
+	passwordCost    int
+}
+
+// Configurable service options, zero values are replaced with defaults
+type Options struct {
+	SessionLifetime time.Duration
+	// bcrypt cost of new password hashes
+	PasswordCost int
+}
+
+func New(userRepo userrepo.UserRepo, log *logrus.Entry, opts Options) UserService {
+	if opts.SessionLifetime <= 0 {
+		opts.SessionLifetime = DefaultSessionLifetime
+	}
+	if opts.PasswordCost == 0 {
+		opts.PasswordCost = bcrypt.DefaultCost
+	}
+	return &service{
+		userRepo:        userRepo,
+		log:             log,
+		sessionLifetime: opts.SessionLifetime,
+		passwordCost:    opts.PasswordCost,
+	}
+}
+
+var validLogin = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,100}$`)
+
+// hash compared with password of unknown user, so response time doesn't tell whether login exists
+var dummyHash struct {
+	once sync.Once
+	hash []byte
+}
+
+func (s *service) compareDummy(password string) {
+	dummyHash.once.Do(func() {
+		dummyHash.hash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), s.passwordCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash.hash, []byte(password))
+}
+
+var invalidCredentials = codes.NewErr(codes.Unauthorized, codes.KnownMessageInvalidCredentials)
+
+func (s *service) Login(ctx context.Context, login, password string) (string, *models.User, error) {
+	user, err := s.userRepo.GetByLogin(ctx, login)
+	if err == sql.ErrNoRows {
+		s.compareDummy(password)
+		return "", nil, invalidCredentials
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		reqinfo.Logger(ctx, s.log).Warnf("failed login of user %s", user.Login)
+		return "", nil, invalidCredentials
+	}
+	token, err := utils.SecretToken()
+	if err != nil {
+		return "", nil, err
+	}
+	session := &models.Session{
+		TokenHash: utils.HashToken(token),
+		UserId:    user.Id,
+		ExpiresAt: time.Now().Add(s.sessionLifetime),
+	}
+	if err := s.userRepo.CreateSession(ctx, session); err != nil {
+		return "", nil, err
+	}
+	return token, user, nil
+}
+
+func (s *service) Authenticate(ctx context.Context, token string) (*models.User, error) {
+	if token == "" {
+		return nil, codes.NewErr(codes.Unauthorized, codes.KnownMessageLoginRequired)
+	}
+	user, err := s.userRepo.GetSessionUser(ctx, utils.HashToken(token), time.Now())
+	if err == sql.ErrNoRows {
+		return nil, codes.NewErr(codes.Unauthorized, codes.KnownMessageLoginRequired)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *service) Logout(ctx context.Context, token string) error {
+	if token == "" {
+		return nil
+	}
+	return s.userRepo.DeleteSession(ctx, utils.HashToken(token))
+}
+
+func (s *service) Create(ctx context.Context, login, password string, role models.Role) (*models.User, error) {
+	if !validLogin.MatchString(login) {
+		return nil, codes.NewErr(codes.InvalidData, codes.KnownMessageInvalidUserLogin)
+	}
+	if !role.IsValid() {
+		return nil, codes.NewErr(codes.InvalidData, codes.KnownMessageInvalidRole)
+	}
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{Login: login, PasswordHash: hash, Role: role}
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		if err == codes.UniqueConstraintViolation {
+			return nil, codes.NewErr(codes.InvalidData, codes.KnownMessageUserLoginTaken)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *service) SetPassword(ctx context.Context, login, password string) error {
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.update(ctx, login, func(user *models.User) {
+		user.PasswordHash = hash
+	})
+}
+
+func (s *service) SetRole(ctx context.Context, login string, role models.Role) error {
+	if !role.IsValid() {
+		return codes.NewErr(codes.InvalidData, codes.KnownMessageInvalidRole)
+	}
+	return s.update(ctx, login, func(user *models.User) {
+		user.Role = role
+	})
+}
+
+// change user and end his sessions, they were started with old password or role
+func (s *service) update(ctx context.Context, login string, change func(user *models.User)) error {
+	user, err := s.userRepo.GetByLogin(ctx, login)
+	if err == sql.ErrNoRows {
+		return codes.NewErr(codes.UserNotFound, codes.KnownMessageUserNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	change(user)
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		if err == codes.NoRowsModified {
+			return codes.NewErr(codes.UserNotFound, codes.KnownMessageUserNotFound)
+		}
+		return err
+	}
+	return s.userRepo.DeleteUserSessions(ctx, user.Id)
+}
+
+func (s *service) List(ctx context.Context) ([]models.User, error) {
+	return s.userRepo.List(ctx)
+}
+
+func (s *service) PurgeSessions(ctx context.Context) (int64, error) {
+	return s.userRepo.DeleteExpiredSessions(ctx, time.Now())
+}
+
+func (s *service) hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return "", codes.NewErr(codes.InvalidData, fmt.Sprintf(codes.KnownMessageInvalidPassword, MinPasswordLength, MaxPasswordLength))
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.passwordCost)
+	return string(hash), err
+}
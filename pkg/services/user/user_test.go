@@ -0,0 +1,204 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/abdybaevae/customers-app/pkg/codes"
+	"github.com/abdybaevae/customers-app/pkg/models"
+	userrepo "github.com/abdybaevae/customers-app/pkg/repos/user"
+	"github.com/abdybaevae/customers-app/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// in memory repository of users and sessions
+type stubRepo struct {
+	userrepo.UserRepo
+	users    map[string]*models.User
+	sessions map[string]*models.Session
+}
+
+func newStubRepo() *stubRepo {
+	return &stubRepo{users: map[string]*models.User{}, sessions: map[string]*models.Session{}}
+}
+
+func (r *stubRepo) Create(ctx context.Context, user *models.User) error {
+	if _, ok := r.users[user.Login]; ok {
+		return codes.UniqueConstraintViolation
+	}
+	user.Id = len(r.users) + 1
+	r.users[user.Login] = user
+	return nil
+}
+
+func (r *stubRepo) GetByLogin(ctx context.Context, login string) (*models.User, error) {
+	user, ok := r.users[login]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copy := *user
+	return &copy, nil
+}
+
+func (r *stubRepo) Update(ctx context.Context, user *models.User) error {
+	r.users[user.Login] = user
+	return nil
+}
+
+func (r *stubRepo) CreateSession(ctx context.Context, session *models.Session) error {
+	r.sessions[session.TokenHash] = session
+	return nil
+}
+
+func (r *stubRepo) GetSessionUser(ctx context.Context, tokenHash string, now time.Time) (*models.User, error) {
+	session, ok := r.sessions[tokenHash]
+	if !ok || !session.ExpiresAt.After(now) {
+		return nil, sql.ErrNoRows
+	}
+	for _, user := range r.users {
+		if user.Id == session.UserId {
+			return user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *stubRepo) DeleteSession(ctx context.Context, tokenHash string) error {
+	delete(r.sessions, tokenHash)
+	return nil
+}
+
+func (r *stubRepo) DeleteUserSessions(ctx context.Context, userId int) error {
+	for hash, session := range r.sessions {
+		if session.UserId == userId {
+			delete(r.sessions, hash)
+		}
+	}
+	return nil
+}
+
+func newTestService(repo userrepo.UserRepo) UserService {
+	return New(repo, logrus.NewEntry(logrus.New()), Options{PasswordCost: bcrypt.MinCost})
+}
+
+func errCode(err error) codes.Code {
+	if errCode, ok := err.(codes.ErrorCode); ok {
+		return errCode.Code()
+	}
+	return ""
+}
+
+func TestLoginAndLogout(t *testing.T) {
+	repo := newStubRepo()
+	service := newTestService(repo)
+	ctx := context.Background()
+	if _, err := service.Create(ctx, "editor", "password1", models.RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	if repo.users["editor"].PasswordHash == "password1" {
+		t.Error("password must be stored as hash")
+	}
+	for _, tc := range [][2]string{{"editor", "wrong password"}, {"nobody", "password1"}} {
+		if _, _, err := service.Login(ctx, tc[0], tc[1]); errCode(err) != codes.Unauthorized {
+			t.Error("invalid credentials must be rejected", tc, err)
+		}
+	}
+	token, user, err := service.Login(ctx, "editor", "password1")
+	if err != nil || user.Role != models.RoleEditor {
+		t.Fatal("login failed", err)
+	}
+	if _, ok := repo.sessions[token]; ok {
+		t.Error("session token must be stored as hash")
+	}
+	if user, err := service.Authenticate(ctx, token); err != nil || user.Login != "editor" {
+		t.Error("session must be authenticated", err)
+	}
+	if err := service.Logout(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Authenticate(ctx, token); errCode(err) != codes.Unauthorized {
+		t.Error("session must be ended by logout", err)
+	}
+}
+
+func TestExpiredSession(t *testing.T) {
+	repo := newStubRepo()
+	service := newTestService(repo)
+	ctx := context.Background()
+	service.Create(ctx, "viewer", "password1", models.RoleViewer)
+	token, _, err := service.Login(ctx, "viewer", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.sessions[utils.HashToken(token)].ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := service.Authenticate(ctx, token); errCode(err) != codes.Unauthorized {
+		t.Error("expired session must be rejected", err)
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	service := newTestService(newStubRepo())
+	ctx := context.Background()
+	tt := []struct {
+		login    string
+		password string
+		role     models.Role
+	}{
+		{"", "password1", models.RoleViewer},
+		{"bad login", "password1", models.RoleViewer},
+		{"user", "short", models.RoleViewer},
+		{"user", string(make([]byte, MaxPasswordLength+1)), models.RoleViewer},
+		{"user", "password1", "root"},
+	}
+	for _, tc := range tt {
+		if _, err := service.Create(ctx, tc.login, tc.password, tc.role); errCode(err) != codes.InvalidData {
+			t.Error("invalid user must be rejected", tc.login, tc.role, err)
+		}
+	}
+	service.Create(ctx, "user", "password1", models.RoleViewer)
+	if _, err := service.Create(ctx, "user", "password2", models.RoleAdmin); err == nil || err.(codes.ErrorCode).Message() != codes.KnownMessageUserLoginTaken {
+		t.Error("taken login must be rejected", err)
+	}
+}
+
+// sessions started with old password or role must not survive their change
+func TestChangesEndSessions(t *testing.T) {
+	repo := newStubRepo()
+	service := newTestService(repo)
+	ctx := context.Background()
+	service.Create(ctx, "user", "password1", models.RoleViewer)
+	token, _, _ := service.Login(ctx, "user", "password1")
+	if err := service.SetRole(ctx, "user", models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Authenticate(ctx, token); errCode(err) != codes.Unauthorized {
+		t.Error("role change must end sessions", err)
+	}
+	token, user, _ := service.Login(ctx, "user", "password1")
+	if user.Role != models.RoleAdmin {
+		t.Error("role wasn't changed", user.Role)
+	}
+	if err := service.SetPassword(ctx, "user", "password2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Authenticate(ctx, token); errCode(err) != codes.Unauthorized {
+		t.Error("password change must end sessions", err)
+	}
+	if _, _, err := service.Login(ctx, "user", "password2"); err != nil {
+		t.Error("new password must be accepted", err)
+	}
+	if err := service.SetRole(ctx, "nobody", models.RoleAdmin); errCode(err) != codes.UserNotFound {
+		t.Error("unknown user must be reported", err)
+	}
+}
+
+func TestRoleScopes(t *testing.T) {
+	if !models.RoleAdmin.Grants(models.ScopeCustomersDelete) || models.RoleEditor.Grants(models.ScopeCustomersDelete) ||
+		!models.RoleEditor.Grants(models.ScopeCustomersWrite) || models.RoleViewer.Grants(models.ScopeCustomersWrite) ||
+		models.Role("root").Grants(models.ScopeCustomersRead) {
+		t.Error("roles must grant viewer < editor < admin scopes")
+	}
+}
@@ -1,10 +1,15 @@
 package utils
 
 import (
-	"io/ioutil"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"html/template"
 	"math/rand"
-	"text/template"
 	"time"
+
+	"github.com/abdybaevae/customers-app/ui"
 )
 
 const customerHashSize = 20
@@ -17,6 +22,11 @@ func FormatBirthDate(birthDate time.Time) string {
 	return birthDate.Format(birthDateLayout)
 }
 
+// parse birthdate of the same format
+func ParseBirthDate(value string) (time.Time, error) {
+	return time.Parse(birthDateLayout, value)
+}
+
 // simple function to return random string hash(of size 20)
 func RandomSizedString(size int) string {
 	if size <= 0 {
@@ -32,18 +42,25 @@ func GenCustomerHash() string {
 	return RandomSizedString(customerHashSize)
 }
 
+// Secret token(session or api key) with 256 bits of randomness, it can't be guessed
+func SecretToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// secret tokens are stored as sha256, random token doesn't need slow hash like password does
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // it would be better to have singleton instance for less memory expenses.
 // probably need to be refactored.
 func LoadTemplates() *template.Template {
-	var allFiles []string
-	files, err := ioutil.ReadDir("./ui/html")
-	if err != nil {
-		panic(err)
-	}
-	for _, file := range files {
-		allFiles = append(allFiles, "./ui/html/"+file.Name())
-	}
-	templates, err := template.ParseFiles(allFiles...)
+	templates, err := template.ParseFS(ui.Files(), "html/*.html")
 	if err != nil {
 		panic(err)
 	}
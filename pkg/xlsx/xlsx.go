@@ -0,0 +1,102 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// Minimal xlsx writer: single sheet of text cells. Workbook is zip archive of xml parts, sheet is the last part,
+// so rows are written to destination as they come and whole sheet is never kept in memory.
+type Writer struct {
+	zw    *zip.Writer
+	sheet io.Writer
+}
+
+const (
+	contentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+	rootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	workbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+	workbookStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="`
+	workbookEnd = `" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	sheetStart  = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	sheetEnd = `</sheetData></worksheet>`
+)
+
+// Start workbook with one sheet of given name, rows are added with WriteRow and workbook is finished with Close.
+func NewWriter(w io.Writer, sheetName string) (*Writer, error) {
+	zw := zip.NewWriter(w)
+	parts := []struct {
+		name    string
+		content []string
+	}{
+		{"[Content_Types].xml", []string{contentTypes}},
+		{"_rels/.rels", []string{rootRels}},
+		{"xl/_rels/workbook.xml.rels", []string{workbookRels}},
+		{"xl/workbook.xml", []string{workbookStart, escape(sheetName), workbookEnd}},
+	}
+	for _, part := range parts {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range part.content {
+			if _, err := io.WriteString(pw, content); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, sheetStart); err != nil {
+		return nil, err
+	}
+	return &Writer{zw: zw, sheet: sheet}, nil
+}
+
+// Append row of text cells. Values are inline strings, so they are never interpreted as formulas.
+func (w *Writer) WriteRow(values []string) error {
+	if _, err := io.WriteString(w.sheet, "<row>"); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if _, err := io.WriteString(w.sheet, `<c t="inlineStr"><is><t xml:space="preserve">`+escape(value)+`</t></is></c>`); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w.sheet, "</row>")
+	return err
+}
+
+// Finish sheet and archive, destination isn't closed.
+func (w *Writer) Close() error {
+	if _, err := io.WriteString(w.sheet, sheetEnd); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+// xml escaping, characters which aren't allowed in xml are replaced too
+func escape(value string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(value))
+	return sb.String()
+}
@@ -0,0 +1,40 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "Customers & co")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteRow([]string{"name", "address"})
+	w.WriteRow([]string{"<John>", "=1+1"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	for _, file := range zr.File {
+		rc, _ := file.Open()
+		content, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		parts[file.Name] = string(content)
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `name="Customers &amp; co"`) {
+		t.Error("sheet name wasn't escaped", parts["xl/workbook.xml"])
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	if strings.Count(sheet, "<row>") != 2 || !strings.Contains(sheet, "&lt;John&gt;") || !strings.HasSuffix(sheet, "</sheetData></worksheet>") {
+		t.Error("unexpected sheet", sheet)
+	}
+}
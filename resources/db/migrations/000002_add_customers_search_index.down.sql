@@ -0,0 +1,2 @@
+drop index if exists customers_search_trgm_idx;
+drop index if exists customers_search_fts_idx;
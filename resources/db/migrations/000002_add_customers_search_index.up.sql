@@ -0,0 +1,9 @@
+create extension if not exists pg_trgm;
+
+-- expressions must be the same as search document in customer repository, otherwise indexes won't be used
+create index if not exists customers_search_fts_idx on customers using gin (
+    to_tsvector('simple', customer_first_name || ' ' || customer_last_name || ' ' || customer_email || ' ' || coalesce(customer_address, ''))
+);
+create index if not exists customers_search_trgm_idx on customers using gin (
+    (customer_first_name || ' ' || customer_last_name || ' ' || customer_email || ' ' || coalesce(customer_address, '')) gin_trgm_ops
+);
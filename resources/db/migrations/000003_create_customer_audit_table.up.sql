@@ -0,0 +1,11 @@
+create table if not exists customer_audit(
+    audit_id bigserial not null primary key,
+    -- customer can be deleted, but his history is kept
+    customer_id integer not null,
+    audit_action varchar(10) not null,
+    audit_changes jsonb not null,
+    audit_actor varchar(150) not null,
+    audit_request_id varchar(100) not null default '',
+    audit_created_at timestamp not null default now()
+);
+create index if not exists customer_audit_customer_idx on customer_audit(customer_id, audit_created_at);
@@ -0,0 +1,5 @@
+delete from customers where customer_deleted_at is not null;
+drop index if exists customers_deleted_at_idx;
+drop index if exists customers_email_active_idx;
+alter table customers add constraint customers_customer_email_key unique (customer_email);
+alter table customers drop column if exists customer_deleted_at;
@@ -0,0 +1,6 @@
+alter table customers add column if not exists customer_deleted_at timestamp;
+
+-- deleted customers keep their email, but it can be used by another customer till restore
+alter table customers drop constraint if exists customers_customer_email_key;
+create unique index if not exists customers_email_active_idx on customers(customer_email) where customer_deleted_at is null;
+create index if not exists customers_deleted_at_idx on customers(customer_deleted_at) where customer_deleted_at is not null;
@@ -0,0 +1,2 @@
+-- original case of emails isn't kept, nothing to revert
+select 1;
@@ -0,0 +1,8 @@
+-- emails are stored trimmed and lower cased since then, existing ones are normalized unless it makes them duplicate
+update customers c
+set customer_email = lower(trim(c.customer_email))
+where c.customer_email <> lower(trim(c.customer_email))
+	and not exists (
+		select 1 from customers o
+		where o.customer_id <> c.customer_id and lower(trim(o.customer_email)) = lower(trim(c.customer_email))
+	);
@@ -0,0 +1,5 @@
+drop trigger if exists customers_rules_check on customers;
+drop function if exists customers_rules_check();
+drop table if exists customer_rules;
+-- longer genders must be removed manually before this migration is reverted
+alter table customers alter column customer_gender type varchar(10);
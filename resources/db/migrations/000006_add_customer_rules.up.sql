@@ -0,0 +1,46 @@
+-- configured genders can be longer than male/female, e.g. unspecified
+alter table customers alter column customer_gender type varchar(30);
+
+-- validation rules from configuration, server stores them on startup. There is only one row.
+create table if not exists customer_rules (
+    rules_id boolean primary key default true check (rules_id),
+    rules_min_age int not null,
+    rules_max_age int not null,
+    rules_genders text[] not null,
+    rules_max_name_length int not null,
+    rules_max_email_length int not null,
+    rules_max_address_length int not null
+);
+insert into customer_rules (rules_min_age, rules_max_age, rules_genders, rules_max_name_length, rules_max_email_length, rules_max_address_length)
+values (18, 60, array['female', 'male', 'unspecified'], 100, 150, 300)
+on conflict do nothing;
+
+-- check constraint can't read other table, so rules are checked by trigger which fails as check constraint.
+-- Age depends on current date, it's checked only when birthdate is set, so old customers can still be deleted and restored.
+create or replace function customers_rules_check() returns trigger as $$
+declare
+    r customer_rules%rowtype;
+begin
+    select * into r from customer_rules;
+    if not found then
+        return new;
+    end if;
+    if char_length(new.customer_first_name) > r.rules_max_name_length
+        or char_length(new.customer_last_name) > r.rules_max_name_length
+        or char_length(new.customer_email) > r.rules_max_email_length
+        or char_length(new.customer_address) > r.rules_max_address_length
+        or new.customer_gender is not null and not new.customer_gender = any(r.rules_genders)
+        or (tg_op = 'INSERT' or new.customer_birth_date is distinct from old.customer_birth_date)
+            and extract(year from age(current_date, new.customer_birth_date)) not between r.rules_min_age and r.rules_max_age
+    then
+        raise exception 'customer % violates validation rules', new.customer_id
+            using errcode = 'check_violation', constraint = 'customers_rules_check';
+    end if;
+    return new;
+end;
+$$ language plpgsql;
+
+drop trigger if exists customers_rules_check on customers;
+create trigger customers_rules_check
+    before insert or update of customer_first_name, customer_last_name, customer_birth_date, customer_gender, customer_email, customer_address
+    on customers for each row execute procedure customers_rules_check();
@@ -0,0 +1,2 @@
+drop table if exists user_sessions;
+drop table if exists users;
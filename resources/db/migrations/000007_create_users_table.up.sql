@@ -0,0 +1,19 @@
+create table if not exists users(
+    user_id serial not null primary key,
+    user_login varchar(100) not null,
+    -- bcrypt hash, password itself is never stored
+    user_password_hash varchar(100) not null,
+    user_role varchar(10) not null,
+    user_created_at timestamp not null default now(),
+    constraint users_role_check check (user_role in ('viewer', 'editor', 'admin'))
+);
+create unique index if not exists users_login_idx on users(lower(user_login));
+-- session cookie holds random token, only its sha256 is stored, so leaked table doesn't give access
+create table if not exists user_sessions(
+    session_token_hash char(64) not null primary key,
+    user_id integer not null references users(user_id) on delete cascade,
+    session_created_at timestamp not null default now(),
+    session_expires_at timestamp not null
+);
+create index if not exists user_sessions_user_idx on user_sessions(user_id);
+create index if not exists user_sessions_expires_idx on user_sessions(session_expires_at);
@@ -0,0 +1,15 @@
+-- keys of machine clients, only sha256 of key is stored(key is random, so it can't be brute forced from hash)
+create table if not exists api_keys(
+    key_id serial not null primary key,
+    key_name varchar(100) not null,
+    -- first characters of key, they identify key in lists and logs
+    key_prefix varchar(20) not null,
+    key_hash char(64) not null unique,
+    key_scopes text[] not null,
+    key_created_by varchar(150) not null,
+    key_created_at timestamp not null default now(),
+    -- null means key doesn't expire
+    key_expires_at timestamp,
+    key_last_used_at timestamp,
+    key_revoked_at timestamp
+);
@@ -0,0 +1,15 @@
+-- responses of unsafe requests sent with Idempotency-Key header, retry with the same key receives stored response
+create table if not exists idempotency_keys(
+    -- actor of request(user login or api key), keys of different clients don't collide
+    idempotency_owner varchar(150) not null,
+    idempotency_key varchar(255) not null,
+    -- sha256 of method, route and body, the same key can't be reused for another request
+    request_fingerprint char(64) not null,
+    -- null while the first request is handled
+    response_status integer,
+    response_headers jsonb,
+    response_body bytea,
+    idempotency_created_at timestamp not null default now(),
+    primary key (idempotency_owner, idempotency_key)
+);
+create index if not exists idempotency_keys_created_at_idx on idempotency_keys(idempotency_created_at);
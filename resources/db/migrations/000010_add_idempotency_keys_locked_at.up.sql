@@ -0,0 +1,2 @@
+-- time when the request with key was started, retry takes over key of request which isn't completed in lock timeout(e.g. server has crashed)
+alter table idempotency_keys add column if not exists idempotency_locked_at timestamp not null default now();
@@ -0,0 +1,8 @@
+package resources
+
+import "embed"
+
+// Migrations are compiled into binary, so it can migrate database from any working directory.
+//
+//go:embed db/migrations/*.sql
+var Migrations embed.FS
@@ -0,0 +1,29 @@
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// Templates(html) and static files(static) are compiled into binary.
+//
+//go:embed html static
+var embedded embed.FS
+
+// directory with the same structure, it's used instead of embedded files if it's set
+var dir string
+
+// Read ui files from directory instead of binary, so templates can be changed without rebuild(restart is still required).
+// It must be called before templates are loaded.
+func SetDir(d string) {
+	dir = d
+}
+
+// ui files: html and static directories
+func Files() fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	return embedded
+}